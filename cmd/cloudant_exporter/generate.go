@@ -0,0 +1,504 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/prometheus/common/model"
+	"gopkg.in/yaml.v3"
+)
+
+// generate writes the named configuration for other tools, built from
+// the metrics the exporter exports, so that it matches the flags given.
+func generate(w io.Writer, what string) error {
+	if err := validateFlags(); err != nil {
+		return err
+	}
+	switch what {
+	case "dashboard":
+		return generateDashboard(w)
+	case "alerts":
+		return generateAlerts(w)
+	case "recording-rules":
+		return generateRecordingRules(w)
+	case "":
+		return fmt.Errorf("generate what? Expected dashboard, alerts or recording-rules")
+	default:
+		return fmt.Errorf("cannot generate %q, expected dashboard, alerts or recording-rules", what)
+	}
+}
+
+// dashboard is the subset of Grafana's dashboard JSON model we need.
+type dashboard struct {
+	UID           string     `json:"uid"`
+	Title         string     `json:"title"`
+	Description   string     `json:"description"`
+	Tags          []string   `json:"tags"`
+	Editable      bool       `json:"editable"`
+	Refresh       string     `json:"refresh"`
+	SchemaVersion int        `json:"schemaVersion"`
+	Time          timeRange  `json:"time"`
+	Templating    templating `json:"templating"`
+	Panels        []panel    `json:"panels"`
+}
+
+type timeRange struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+type templating struct {
+	List []variable `json:"list"`
+}
+
+type variable struct {
+	Name       string      `json:"name"`
+	Label      string      `json:"label,omitempty"`
+	Type       string      `json:"type"`
+	Query      interface{} `json:"query"`
+	Datasource *datasource `json:"datasource,omitempty"`
+	Refresh    int         `json:"refresh,omitempty"`
+	IncludeAll bool        `json:"includeAll"`
+	Multi      bool        `json:"multi"`
+	AllValue   string      `json:"allValue,omitempty"`
+}
+
+type datasource struct {
+	Type string `json:"type"`
+	UID  string `json:"uid"`
+}
+
+type gridPos struct {
+	H int `json:"h"`
+	W int `json:"w"`
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+type panel struct {
+	ID          int          `json:"id"`
+	Type        string       `json:"type"`
+	Title       string       `json:"title"`
+	Description string       `json:"description,omitempty"`
+	GridPos     gridPos      `json:"gridPos"`
+	Datasource  *datasource  `json:"datasource,omitempty"`
+	FieldConfig *fieldConfig `json:"fieldConfig,omitempty"`
+	Targets     []target     `json:"targets,omitempty"`
+}
+
+type fieldConfig struct {
+	Defaults fieldDefaults `json:"defaults"`
+}
+
+type fieldDefaults struct {
+	Unit string `json:"unit,omitempty"`
+}
+
+type target struct {
+	RefID        string      `json:"refId"`
+	Datasource   *datasource `json:"datasource"`
+	Expr         string      `json:"expr"`
+	LegendFormat string      `json:"legendFormat,omitempty"`
+	Format       string      `json:"format,omitempty"`
+	Instant      bool        `json:"instant,omitempty"`
+}
+
+// promDatasource is the dashboard's Prometheus data source,
+// chosen with the datasource variable.
+var promDatasource = &datasource{Type: "prometheus", UID: "${datasource}"}
+
+// uidRegexp matches the characters Grafana doesn't allow in a UID.
+var uidRegexp = regexp.MustCompile(`[^a-zA-Z0-9_-]+`)
+
+// generateDashboard writes a Grafana dashboard with a panel for each
+// metric family, in a row for each part of the exporter, eg database or
+// replication. Each -label becomes a variable filtering the panels.
+func generateDashboard(w io.Writer) error {
+	families, err := exportedFamilies()
+	if err != nil {
+		return err
+	}
+	labels, err := parseLabels(constLabels)
+	if err != nil {
+		return fmt.Errorf("could not parse -label: %w", err)
+	}
+	constNames := make([]string, 0, len(labels))
+	for l := range labels {
+		constNames = append(constNames, l)
+	}
+	sort.Strings(constNames)
+
+	prefix := strings.TrimSuffix(*metricPrefix, "_")
+	uid := uidRegexp.ReplaceAllString(prefix, "-") + "-exporter"
+	if len(uid) > 40 {
+		uid = uid[:40]
+	}
+	d := dashboard{
+		UID:           uid,
+		Title:         fmt.Sprintf("Cloudant (%s)", prefix),
+		Description:   fmt.Sprintf("Generated by %s %s from the metrics it exports.", AppName, Version),
+		Tags:          []string{"cloudant", "cloudant-exporter"},
+		Editable:      true,
+		Refresh:       "1m",
+		SchemaVersion: 36,
+		Time:          timeRange{From: "now-6h", To: "now"},
+		Templating: templating{List: []variable{{
+			Name:  "datasource",
+			Label: "Data source",
+			Type:  "datasource",
+			Query: "prometheus",
+		}}},
+	}
+	// filter every panel on each constant label
+	matchers := []string{}
+	for _, l := range constNames {
+		d.Templating.List = append(d.Templating.List, variable{
+			Name: l,
+			Type: "query",
+			Query: map[string]string{
+				"query": fmt.Sprintf("label_values(%s, %s)", *metricPrefix+"exporter_monitor_up", l),
+				"refId": "PrometheusVariableQueryEditor-VariableQuery",
+			},
+			Datasource: promDatasource,
+			Refresh:    2,
+			IncludeAll: true,
+			Multi:      true,
+			AllValue:   ".*",
+		})
+		matchers = append(matchers, fmt.Sprintf(`%s=~"$%s"`, l, l))
+	}
+	selector := ""
+	if len(matchers) > 0 {
+		selector = "{" + strings.Join(matchers, ",") + "}"
+	}
+
+	id, y := 0, 0
+	row, x := "", 0
+	for _, f := range families {
+		short := strings.TrimPrefix(f.Name, *metricPrefix)
+		group, _, _ := strings.Cut(short, "_")
+		if group != row {
+			row = group
+			if x > 0 {
+				y += 8
+			}
+			id++
+			d.Panels = append(d.Panels, panel{
+				ID:      id,
+				Type:    "row",
+				Title:   strings.ToUpper(group[:1]) + group[1:],
+				GridPos: gridPos{H: 1, W: 24, X: 0, Y: y},
+			})
+			y++
+			x = 0
+		}
+
+		id++
+		p := familyPanel(f, selector, constNames)
+		p.ID = id
+		p.Title = short
+		p.Description = f.Help
+		p.GridPos = gridPos{H: 8, W: 12, X: x, Y: y}
+		d.Panels = append(d.Panels, p)
+		if x += 12; x == 24 {
+			x = 0
+			y += 8
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(d)
+}
+
+// familyPanel returns a panel graphing f's series in the way that suits
+// its type: counters as rates, histograms as quantiles, info metrics as
+// tables.
+func familyPanel(f registry.Family, selector string, constNames []string) panel {
+	// the constant labels are filtered on, so needn't be in the legend
+	legend := []string{}
+	for _, l := range f.Labels {
+		if !contains(constNames, l) {
+			legend = append(legend, "{{"+l+"}}")
+		}
+	}
+	p := panel{
+		Type:        "timeseries",
+		Datasource:  promDatasource,
+		FieldConfig: &fieldConfig{Defaults: fieldDefaults{Unit: unit(f)}},
+	}
+	t := target{
+		RefID:        "A",
+		Datasource:   promDatasource,
+		Expr:         f.Name + selector,
+		LegendFormat: strings.Join(legend, " "),
+	}
+	switch {
+	case strings.HasSuffix(f.Name, "_info"):
+		p.Type = "table"
+		p.FieldConfig = nil
+		t.Format, t.Instant, t.LegendFormat = "table", true, ""
+	case f.Type == "counter":
+		t.Expr = fmt.Sprintf("rate(%s%s[$__rate_interval])", f.Name, selector)
+	case f.Type == "histogram":
+		by := []string{"le"}
+		for _, l := range f.Labels {
+			if !contains(constNames, l) {
+				by = append(by, l)
+			}
+		}
+		t.Expr = fmt.Sprintf("histogram_quantile(0.95, sum by (%s) (rate(%s_bucket%s[$__rate_interval])))",
+			strings.Join(by, ", "), f.Name, selector)
+		if t.LegendFormat == "" {
+			t.LegendFormat = "p95"
+		} else {
+			t.LegendFormat += " p95"
+		}
+	case strings.HasSuffix(f.Name, "_timestamp_seconds"):
+		// Grafana's times are in milliseconds
+		t.Expr = fmt.Sprintf("%s%s * 1000", f.Name, selector)
+	case f.Type == "summary":
+		t.Expr = fmt.Sprintf("rate(%s_sum%s[$__rate_interval]) / rate(%s_count%s[$__rate_interval])",
+			f.Name, selector, f.Name, selector)
+	}
+	p.Targets = []target{t}
+	return p
+}
+
+// unit returns the Grafana unit for f's panel, from its name's suffix.
+func unit(f registry.Family) string {
+	name := f.Name
+	if f.Type == "counter" {
+		if strings.HasSuffix(name, "_bytes_total") {
+			return "Bps"
+		}
+		return "ops"
+	}
+	switch {
+	case strings.HasSuffix(name, "_timestamp_seconds"):
+		return "dateTimeAsIso"
+	case strings.HasSuffix(name, "_seconds"):
+		return "s"
+	case strings.HasSuffix(name, "_bytes"):
+		return "bytes"
+	case strings.HasSuffix(name, "_ratio"):
+		return "percentunit"
+	case strings.HasSuffix(name, "_percent"):
+		return "percent"
+	case strings.HasSuffix(name, "_per_second"):
+		return "reqps"
+	}
+	return "short"
+}
+
+func contains(l []string, s string) bool {
+	for _, v := range l {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// ruleGroups is a Prometheus rules file.
+type ruleGroups struct {
+	Groups []ruleGroup `yaml:"groups"`
+}
+
+type ruleGroup struct {
+	Name  string `yaml:"name"`
+	Rules []rule `yaml:"rules"`
+}
+
+type rule struct {
+	Alert       string            `yaml:"alert,omitempty"`
+	Record      string            `yaml:"record,omitempty"`
+	Expr        string            `yaml:"expr"`
+	For         string            `yaml:"for,omitempty"`
+	Labels      map[string]string `yaml:"labels,omitempty"`
+	Annotations map[string]string `yaml:"annotations,omitempty"`
+}
+
+// alertSpec is an alert on the exported metric, with the given name
+// without its prefix, which needs the metric to have the given labels.
+// The {metric} in Expr is replaced by the metric's exported name.
+type alertSpec struct {
+	rule
+	metric string
+	needs  []string
+}
+
+// generateAlerts writes Prometheus alerting rules for common problems,
+// with the thresholds set by the -alerts.* flags. Alerts on metrics
+// missing the labels they need, eg because of -label-drop, are skipped.
+func generateAlerts(w io.Writer) error {
+	families, err := exportedFamilies()
+	if err != nil {
+		return err
+	}
+	byName := map[string]registry.Family{}
+	for _, f := range families {
+		byName[f.Name] = f
+	}
+
+	specs := []alertSpec{{
+		metric: "exporter_monitor_up",
+		needs:  []string{"monitor"},
+		rule: rule{
+			Alert: "CloudantExporterMonitorDown",
+			Expr:  "{metric} == 0",
+			Annotations: map[string]string{
+				"summary":     "Cloudant exporter monitor {{ $labels.monitor }} is failing",
+				"description": "The {{ $labels.monitor }} monitor has been unable to poll Cloudant, so its metrics are stale.",
+			},
+		},
+	}, {
+		metric: "replication_status_count",
+		needs:  []string{"status"},
+		rule: rule{
+			Alert: "CloudantReplicationsCrashing",
+			Expr:  fmt.Sprintf(`{metric}{status="crashing"} > %d`, *alertsReplicationsCrashing),
+			Annotations: map[string]string{
+				"summary":     "Cloudant replications are crashing",
+				"description": "{{ $value }} replications are crashing, more than the " + strconv.Itoa(*alertsReplicationsCrashing) + " expected.",
+			},
+		},
+	}, {
+		metric: "throughput_ratelimited_requests_total",
+		needs:  []string{"class"},
+		rule: rule{
+			Alert: "CloudantRateLimited",
+			Expr: fmt.Sprintf("rate({metric}[5m]) / rate(%s[5m]) > %s",
+				*metricPrefix+"throughput_requests_total", strconv.FormatFloat(*alertsRateLimitedRatio, 'g', -1, 64)),
+			Annotations: map[string]string{
+				"summary":     "Cloudant is rate limiting {{ $labels.class }} requests",
+				"description": "{{ $value | humanizePercentage }} of {{ $labels.class }} requests are rejected with a 429; consider more provisioned throughput.",
+			},
+		},
+	}, {
+		metric: "view_index_lag_changes",
+		needs:  []string{"database", "design_document"},
+		rule: rule{
+			Alert: "CloudantViewIndexLagging",
+			Expr:  fmt.Sprintf("{metric} > %d", *alertsIndexLagChanges),
+			Annotations: map[string]string{
+				"summary":     "Cloudant view index {{ $labels.database }}/{{ $labels.design_document }} is lagging",
+				"description": "The view index is {{ $value }} changes behind its database, so queries with update=false return stale results and others are slow.",
+			},
+		},
+	}}
+
+	group := ruleGroup{Name: "cloudant-exporter-alerts"}
+	for _, spec := range specs {
+		name := *metricPrefix + spec.metric
+		if err := hasLabels(byName, name, spec.needs); err != nil {
+			slog.Warn("Skipping alert", "alert", spec.Alert, "err", err)
+			continue
+		}
+		r := spec.rule
+		r.Expr = strings.ReplaceAll(r.Expr, "{metric}", name)
+		if *alertsFor > 0 {
+			r.For = model.Duration(*alertsFor).String()
+		}
+		r.Labels = map[string]string{"severity": "warning"}
+		group.Rules = append(group.Rules, r)
+	}
+	return writeRules(w, group)
+}
+
+// generateRecordingRules writes Prometheus recording rules for the
+// aggregations commonly graphed, eg replication throughput per route,
+// summing away whichever labels the exported metrics still have.
+func generateRecordingRules(w io.Writer) error {
+	families, err := exportedFamilies()
+	if err != nil {
+		return err
+	}
+	byName := map[string]registry.Family{}
+	for _, f := range families {
+		byName[f.Name] = f
+	}
+	p := *metricPrefix
+
+	// sumRate returns the rate of the counter with the given name without
+	// its prefix, summed over those of the labels it has, eg for the
+	// account, or the empty string if it isn't exported.
+	sumRate := func(metric string, over ...string) string {
+		name := p + metric
+		f, ok := byName[name]
+		if !ok {
+			slog.Warn("Skipping rules for a metric not exported", "metric", name)
+			return ""
+		}
+		rate := fmt.Sprintf("rate(%s[5m])", name)
+		without := []string{}
+		for _, l := range over {
+			if contains(f.Labels, l) {
+				without = append(without, l)
+			}
+		}
+		if len(without) == 0 {
+			return rate
+		}
+		return fmt.Sprintf("sum without (%s) (%s)", strings.Join(without, ", "), rate)
+	}
+
+	group := ruleGroup{Name: "cloudant-exporter-recording"}
+	record := func(name, expr string) {
+		if expr != "" {
+			group.Rules = append(group.Rules, rule{Record: name, Expr: expr})
+		}
+	}
+
+	record("account:"+p+"database_updates:rate5m", sumRate("database_updates_total", "database"))
+	record("account:"+p+"database_document_writes:rate5m", sumRate("database_document_writes_total", "database"))
+	record("account:"+p+"replication_docs_written:rate5m", sumRate("replication_docs_written_total", "database", "docid"))
+	record("class:"+p+"throughput_requests:rate5m", sumRate("throughput_requests_total"))
+	if rl, req := sumRate("throughput_ratelimited_requests_total"), sumRate("throughput_requests_total"); rl != "" && req != "" {
+		record("class:"+p+"throughput_ratelimited_requests:ratio_rate5m", rl+" / "+req)
+	}
+
+	// the route comes from each replication's topology, by database and docid
+	written, topology := p+"replication_docs_written_total", p+"replication_topology"
+	if err := hasLabels(byName, written, []string{"database", "docid"}); err != nil {
+		slog.Warn("Skipping per-route rule", "err", err)
+	} else if err := hasLabels(byName, topology, []string{"database", "docid", "source_host", "target_host"}); err != nil {
+		slog.Warn("Skipping per-route rule", "err", err)
+	} else {
+		record("route:"+p+"replication_docs_written:rate5m", fmt.Sprintf(
+			"sum without (database, docid) (rate(%s[5m]) * ignoring (source_host, target_host) group_left (source_host, target_host) %s)",
+			written, topology))
+	}
+
+	return writeRules(w, group)
+}
+
+// hasLabels checks the named family is exported, with the given labels.
+func hasLabels(families map[string]registry.Family, name string, labels []string) error {
+	f, ok := families[name]
+	if !ok {
+		return fmt.Errorf("%s is not exported", name)
+	}
+	for _, l := range labels {
+		if !contains(f.Labels, l) {
+			return fmt.Errorf("%s has no %s label", name, l)
+		}
+	}
+	return nil
+}
+
+func writeRules(w io.Writer, groups ...ruleGroup) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(ruleGroups{Groups: groups}); err != nil {
+		return err
+	}
+	return enc.Close()
+}
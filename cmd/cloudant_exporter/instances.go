@@ -0,0 +1,273 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"cloudant.com/cloudant_exporter/internal/utils"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+)
+
+// scrapeTimeout is how long to wait for an instance's exporter
+// to return its metrics.
+const scrapeTimeout = 10 * time.Second
+
+// instance is an exporter process monitoring one Cloudant instance.
+type instance struct {
+	name string
+	addr string
+	cmd  *exec.Cmd
+}
+
+// runInstances monitors each of the named Cloudant instances, serving
+// their metrics together, labelled with instance_name. As the monitors'
+// metrics belong to the process, each instance is monitored by its own
+// exporter process, started with -instance-name and listening on a
+// local port, whose metrics are scraped when ours are.
+func runInstances(names []string) {
+	if err := validateFlags(); err != nil {
+		fatal("Invalid configuration", "err", err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		fatal("Could not find the exporter executable", "err", err)
+	}
+
+	insts := []*instance{}
+	exited := make(chan string)
+	for _, name := range names {
+		inst, err := startInstance(exe, name)
+		if err != nil {
+			stopInstances(insts)
+			fatal("Could not start instance", "instance_name", name, "err", err)
+		}
+		insts = append(insts, inst)
+		go func(inst *instance) {
+			err := inst.cmd.Wait()
+			slog.Info("Instance exited", "instance_name", inst.name, "err", err)
+			exited <- inst.name
+		}(inst)
+	}
+	defer stopInstances(insts)
+
+	reg := prometheus.NewRegistry()
+	ig := &instancesGatherer{
+		instances: insts,
+		client:    &http.Client{Timeout: scrapeTimeout},
+		up: promauto.With(reg).NewGaugeVec(prometheus.GaugeOpts{
+			Name: "cloudant_exporter_instance_up",
+			Help: "Whether the instance's exporter returned its metrics (1) or not (0)",
+		},
+			[]string{"instance_name"},
+		),
+	}
+	own, err := exportedGatherer(reg)
+	if err != nil {
+		fatal("Invalid configuration", "err", err)
+	}
+	// the instances' metrics are already renamed and labelled
+	// by their own exporters, so are served as they are
+	http.Handle("/metrics", promhttp.InstrumentMetricHandler(
+		reg, promhttp.HandlerFor(prometheus.Gatherers{ig, own}, promhttp.HandlerOpts{}),
+	))
+	http.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		if err := signalInstances(insts, syscall.SIGHUP); err != nil {
+			http.Error(w, fmt.Sprintf("Failed to reload config: %v", err), http.StatusInternalServerError)
+		}
+	})
+	server := &http.Server{
+		Addr:              *addr,
+		ReadHeaderTimeout: 3 * time.Second,
+	}
+	go func() {
+		if err := serve(server); !errors.Is(err, http.ErrServerClosed) {
+			fatal("HTTP server failed", "err", err)
+		}
+	}()
+	slog.Info("HTTP server started", "address", *addr, "instances", len(insts))
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	// the instances must not outlive us
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+	for {
+		select {
+		case <-hup:
+			if err := signalInstances(insts, syscall.SIGHUP); err != nil {
+				slog.Error("Could not reload configuration", "err", err)
+			}
+		case sig := <-term:
+			slog.Info("Shutting down instances", "signal", sig)
+			shutdownInstances(server, insts, exited)
+			return
+		case name := <-exited:
+			// as with a single instance, exit so we get restarted
+			slog.Error("An instance died! Exiting.", "instance_name", name)
+			return
+		}
+	}
+}
+
+// startInstance starts an exporter process for the named instance,
+// with the same arguments as ours, listening on a free local port.
+func startInstance(exe, name string) (*instance, error) {
+	addr, err := freeAddr()
+	if err != nil {
+		return nil, err
+	}
+	// later flags override earlier ones; we scrape the instance
+	// on its own local port, without TLS or authentication
+	args := append([]string{}, os.Args[1:]...)
+	args = append(args, "-instance-name", name, "-listen-address", addr, "-web.config.file", "", "-web.systemd-socket=false")
+	cmd := exec.Command(exe, args...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	slog.Info("Started instance", "instance_name", name, "pid", cmd.Process.Pid, "address", addr)
+	return &instance{name: name, addr: addr, cmd: cmd}, nil
+}
+
+// freeAddr returns a local address that's free to listen on. Another
+// process could take it before the instance does, but then the instance
+// exits, and so do we, to be restarted.
+func freeAddr() (string, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return l.Addr().String(), nil
+}
+
+// shutdownInstances stops server accepting scrapes, and asks the
+// instances to shut down, waiting for up to -shutdown-timeout for
+// them to finish. Any left running are killed when we return.
+func shutdownInstances(server *http.Server, insts []*instance, exited <-chan string) {
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	for _, inst := range insts {
+		// some may have exited already
+		_ = inst.cmd.Process.Signal(syscall.SIGTERM)
+	}
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("Could not finish serving scrapes", "err", err)
+	}
+	for range insts {
+		select {
+		case <-exited:
+		case <-ctx.Done():
+			slog.Warn("Timed out waiting for instances to shut down; killing them")
+			return
+		}
+	}
+	slog.Info("Shut down")
+}
+
+func signalInstances(insts []*instance, sig os.Signal) error {
+	for _, inst := range insts {
+		if err := inst.cmd.Process.Signal(sig); err != nil {
+			return fmt.Errorf("instance %q: %w", inst.name, err)
+		}
+	}
+	return nil
+}
+
+func stopInstances(insts []*instance) {
+	for _, inst := range insts {
+		_ = inst.cmd.Process.Kill()
+	}
+}
+
+// instancesGatherer scrapes the metrics of each instance's exporter,
+// adding an instance_name label to them.
+type instancesGatherer struct {
+	instances []*instance
+	client    *http.Client
+	up        *prometheus.GaugeVec
+}
+
+func (ig *instancesGatherer) Gather() ([]*dto.MetricFamily, error) {
+	results := make([]map[string]*dto.MetricFamily, len(ig.instances))
+	var wg sync.WaitGroup
+	for i, inst := range ig.instances {
+		wg.Add(1)
+		go func(i int, inst *instance) {
+			defer wg.Done()
+			families, err := ig.scrape(inst)
+			if err != nil {
+				// serve the other instances' metrics regardless
+				slog.Warn("Could not scrape instance", "instance_name", inst.name, "err", err)
+				ig.up.WithLabelValues(inst.name).Set(0)
+				return
+			}
+			ig.up.WithLabelValues(inst.name).Set(1)
+			results[i] = families
+		}(i, inst)
+	}
+	wg.Wait()
+
+	// merge each instance's series into a single family per metric name
+	merged := map[string]*dto.MetricFamily{}
+	for i, families := range results {
+		for name, mf := range families {
+			for _, m := range mf.Metric {
+				m.Label = append(m.Label, &dto.LabelPair{
+					Name:  proto.String("instance_name"),
+					Value: proto.String(ig.instances[i].name),
+				})
+				sort.Sort(utils.LabelPairSorter(m.Label))
+			}
+			if existing, ok := merged[name]; ok {
+				existing.Metric = append(existing.Metric, mf.Metric...)
+			} else {
+				merged[name] = mf
+			}
+		}
+	}
+
+	families := make([]*dto.MetricFamily, 0, len(merged))
+	for _, mf := range merged {
+		families = append(families, mf)
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].GetName() < families[j].GetName()
+	})
+	return families, nil
+}
+
+func (ig *instancesGatherer) scrape(inst *instance) (map[string]*dto.MetricFamily, error) {
+	resp, err := ig.client.Get("http://" + inst.addr + "/metrics")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(resp.Body)
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"net/url"
+	"os"
+
+	"cloudant.com/cloudant_exporter/internal/config"
+)
+
+// setupLogging sets the default logger, which the monitors use too, to
+// log at -log.level in -log.format, with the Cloudant instance on every
+// line. Anything still using the log package goes through it too.
+func setupLogging() error {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return fmt.Errorf("invalid -log.level %q, expected debug, info, warn or error", *logLevel)
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var handler slog.Handler
+	switch *logFormat {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("invalid -log.format %q, expected text or json", *logFormat)
+	}
+	logger := slog.New(handler)
+	if instance := logInstance(); instance != "" {
+		logger = logger.With("instance", instance)
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// logInstance returns the -instance-name, or else the host of the
+// Cloudant URL, if it's known yet.
+func logInstance() string {
+	if *instanceName != "" {
+		return *instanceName
+	}
+	u, err := url.Parse(os.Getenv(config.ServiceName("") + "_URL"))
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+// fatal logs msg, with args, as an error and exits, like log.Fatal.
+func fatal(msg string, args ...interface{}) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
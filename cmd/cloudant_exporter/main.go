@@ -1,112 +1,935 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"math/rand"
 	"net/http"
+	"net/url"
+	"os"
+	"os/signal"
+	"regexp"
 	"runtime"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/IBM/go-sdk-core/v5/core"
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/exporter-toolkit/web"
 
+	"cloudant.com/cloudant_exporter/internal/auth"
+	"cloudant.com/cloudant_exporter/internal/config"
 	"cloudant.com/cloudant_exporter/internal/monitors"
+	"cloudant.com/cloudant_exporter/internal/ratelimit"
+	"cloudant.com/cloudant_exporter/internal/registry"
 	"cloudant.com/cloudant_exporter/internal/utils"
+	"cloudant.com/cloudant_exporter/internal/vault"
 )
 
 var AppName = "cloudant_exporter"
 var Version = "development"
 
+// Commit is the git commit the exporter was built from. It's set by
+// the Makefile, or read from the build info by `go build`.
+var Commit = ""
+
+var once = flag.Bool("once", false, "Run each enabled monitor once, print the metrics to stdout, and exit, non-zero if any monitor failed.")
+var showVersion = flag.Bool("version", false, "Print the version and exit.")
+
+var configFile = flag.String("config", "", "YAML configuration file; command line flags and CLOUDANT_* environment variables override its values.")
 var addr = flag.String("listen-address", "127.0.0.1:8080", "The address to listen on for HTTP requests.")
+var instances = flag.String("instances", "", "Comma-separated names of Cloudant instances to monitor, each configured by CLOUDANT_<NAME>_* environment variables; their metrics are labelled with instance_name.")
+var instanceName = flag.String("instance-name", "", "Monitor only the named instance from -instances; used by the exporter to run each instance.")
+var module = flag.String("module", "", "Apply the settings of the named module from the -config file, eg for the targets of a /probe.")
+var probeAllowedTargets = flag.String("probe.allowed-targets", "", "Regular expression matching the Cloudant URLs /probe may be asked to monitor, with the exporter's credentials (default /probe disabled).")
+var constLabels config.RepeatedFlag
+var labelDrops, labelKeeps config.RepeatedFlag
+var metricPrefix = flag.String("metric-prefix", utils.DefaultMetricPrefix, "Prefix for the names of the exported metrics, in place of cloudant_.")
+var partitions = flag.String("partitions", "", "Comma-separated list of database:partition pairs to monitor.")
+var nodeStats = flag.Bool("node-stats", false, "Export per-node statistics (CouchDB and Cloudant dedicated only).")
+var nodeSystem = flag.Bool("node-system", false, "Export per-node Erlang VM metrics (CouchDB and Cloudant dedicated only).")
+var nodeMessageQueuesTopK = flag.Int("node-message-queues-top-k", 10, "Only export the K longest Erlang message queues on each node, with -node-system.")
+var nodePrometheus = flag.Bool("node-prometheus", false, "Re-export each node's native /_node/{node}/_prometheus metrics (CouchDB 3.2+ only).")
+var nodeUp = flag.Bool("node-up", false, "Check each node's /_node/{node}/_up endpoint (CouchDB 3.x and Cloudant dedicated only).")
+var reshard = flag.Bool("reshard", false, "Export the state of resharding jobs (CouchDB 3.x and Cloudant dedicated only).")
+var security = flag.Bool("security", false, "Export a summary of each database's security object.")
+var conflictsDbs = flag.String("conflicts-databases", "", "Comma-separated list of databases in which to count conflicted documents (default none).")
+var databasesInclude = flag.String("databases.include", "", "Regular expression; the per-database monitors only look at matching databases (default all).")
+var databasesExclude = flag.String("databases.exclude", "", "Regular expression; the per-database monitors skip matching databases, eg ^_ for system databases.")
+var databasesTopN = flag.Int("databases-top-n", 0, "Only export per-database statistics for the N largest databases (default all).")
+var canaryDb = flag.String("canary-database", "", "Database in which to write and read a canary document (default disabled).")
+var backupDb = flag.String("backup-database", "", "Database holding records of backups, to report their age and size (default disabled).")
+var replicationProbeSource = flag.String("replication-probe-source", "", "Source database for the probe replication (default disabled).")
+var replicationProbeTarget = flag.String("replication-probe-target", "", "Target database for the probe replication.")
+var findProbes config.RepeatedFlag
+var viewProbes config.RepeatedFlag
+var searchProbes config.RepeatedFlag
+var driftReplications = flag.String("drift-replications", "", "Comma-separated list of docid:source:target replications whose document counts to compare.")
+var replicationStallAfter = flag.Duration("replication-stall-after", 10*time.Minute, "How long a replication with changes pending can write nothing before it counts as stalled.")
+var cloudStatusRegion = flag.String("cloud-status-region", "", "IBM Cloud region, eg us-south, to check for Cloudant incidents on the IBM Cloud status feed (default disabled).")
+var cloudStatusURL = flag.String("cloud-status-url", monitors.DefaultCloudStatusURL, "URL of the IBM Cloud status RSS feed.")
+var replicatorDbs = flag.String("replicator-databases", "", "Comma-separated list of replicator databases to monitor (default all).")
+
+// The monitors which run by default, each of which can be turned off
+// with eg -collector.throughput=false. Pass -collector.disable-defaults
+// to turn them all off, other than those explicitly turned on.
+var (
+	disableDefaultCollectors = flag.Bool("collector.disable-defaults", false, "Disable all the default collectors, other than those explicitly enabled.")
+	collectors               = map[string]*bool{}
+
+	collectReplicationProgress = collectorFlag("replication-progress")
+	collectReplicationStatus   = collectorFlag("replication-status")
+	collectSchedulerDocs       = collectorFlag("scheduler-docs")
+	collectReplicationJobs     = collectorFlag("replication-jobs")
+	collectThroughput          = collectorFlag("throughput")
+	collectCapacity            = collectorFlag("capacity")
+	collectCors                = collectorFlag("cors")
+	collectSession             = collectorFlag("session")
+	collectServerInfo          = collectorFlag("server-info")
+	collectActiveTasks         = collectorFlag("active-tasks")
+	collectDatabases           = collectorFlag("databases")
+	collectDatabaseCount       = collectorFlag("database-count")
+	collectDbUpdates           = collectorFlag("db-updates")
+	collectMembership          = collectorFlag("membership")
+	collectUp                  = collectorFlag("up")
+
+	// These make requests for every database on each poll, which is
+	// slow and costly on accounts with many databases, so they only
+	// run when turned on, eg -collector.view-indexes.
+	collectDesignDocs    = optInCollectorFlag("design-docs")
+	collectMangoIndexes  = optInCollectorFlag("mango-indexes")
+	collectSearchIndexes = optInCollectorFlag("search-indexes")
+	collectViewIndexes   = optInCollectorFlag("view-indexes")
+	collectShards        = optInCollectorFlag("shards")
+)
+
+// How often each monitor polls. The expensive monitors, which make a
+// request per database, may need slowing down on large accounts.
+var (
+	replicationProgressInterval = flag.Duration("replication-progress-interval", 5*time.Second, "How often to poll the progress of running replications.")
+	replicationStatusInterval   = flag.Duration("replication-status-interval", 10*time.Minute, "How often to poll the status of all replications.")
+	schedulerDocsInterval       = flag.Duration("scheduler-docs-interval", 1*time.Minute, "How often to count scheduler documents by state.")
+	replicationJobsInterval     = flag.Duration("replication-jobs-interval", 1*time.Minute, "How often to poll replication jobs.")
+	throughputInterval          = flag.Duration("throughput-interval", 5*time.Second, "How often to poll throughput.")
+	capacityInterval            = flag.Duration("capacity-interval", 5*time.Minute, "How often to poll provisioned capacity.")
+	corsInterval                = flag.Duration("cors-interval", 10*time.Minute, "How often to poll the CORS configuration.")
+	sessionInterval             = flag.Duration("session-interval", 1*time.Minute, "How often to check that the credentials are accepted.")
+	serverInfoInterval          = flag.Duration("server-info-interval", 1*time.Hour, "How often to poll the server version.")
+	activeTasksInterval         = flag.Duration("active-tasks-interval", 5*time.Second, "How often to poll active tasks.")
+	databasesInterval           = flag.Duration("databases-interval", 5*time.Minute, "How often to poll per-database statistics.")
+	databaseCountInterval       = flag.Duration("database-count-interval", 1*time.Minute, "How often to count databases.")
+	dbUpdatesInterval           = flag.Duration("db-updates-interval", 30*time.Second, "How often to poll the database updates feed.")
+	membershipInterval          = flag.Duration("membership-interval", 1*time.Minute, "How often to poll cluster membership.")
+	upInterval                  = flag.Duration("up-interval", 30*time.Second, "How often to check the service is up.")
+	designDocsInterval          = flag.Duration("design-docs-interval", 10*time.Minute, "How often to count design documents in each database.")
+	mangoIndexesInterval        = flag.Duration("mango-indexes-interval", 10*time.Minute, "How often to count Cloudant Query indexes in each database.")
+	searchIndexesInterval       = flag.Duration("search-indexes-interval", 10*time.Minute, "How often to poll search indexes in each database.")
+	viewIndexesInterval         = flag.Duration("view-indexes-interval", 10*time.Minute, "How often to poll view indexes in each database.")
+	shardsInterval              = flag.Duration("shards-interval", 10*time.Minute, "How often to poll shard placement.")
+	nodeStatsInterval           = flag.Duration("node-stats-interval", 30*time.Second, "How often to poll per-node statistics, with -node-stats.")
+	nodeSystemInterval          = flag.Duration("node-system-interval", 30*time.Second, "How often to poll per-node Erlang VM metrics, with -node-system.")
+	nodePrometheusInterval      = flag.Duration("node-prometheus-interval", 30*time.Second, "How often to scrape each node's native metrics, with -node-prometheus.")
+	reshardInterval             = flag.Duration("reshard-interval", 1*time.Minute, "How often to poll resharding jobs, with -reshard.")
+	securityInterval            = flag.Duration("security-interval", 10*time.Minute, "How often to poll security objects, with -security.")
+	conflictsInterval           = flag.Duration("conflicts-interval", 10*time.Minute, "How often to count conflicted documents, with -conflicts-databases.")
+	backupsInterval             = flag.Duration("backups-interval", 10*time.Minute, "How often to read backup records, with -backup-database.")
+	canaryInterval              = flag.Duration("canary-interval", 30*time.Second, "How often to write and read the canary document, with -canary-database.")
+	replicationProbeInterval    = flag.Duration("replication-probe-interval", 5*time.Minute, "How often to run the probe replication.")
+	findProbesInterval          = flag.Duration("find-probe-interval", 1*time.Minute, "How often to run each -find-probe.")
+	viewProbesInterval          = flag.Duration("view-probe-interval", 1*time.Minute, "How often to run each -view-probe.")
+	searchProbesInterval        = flag.Duration("search-probe-interval", 1*time.Minute, "How often to run each -search-probe.")
+	docCountDriftInterval       = flag.Duration("drift-replications-interval", 5*time.Minute, "How often to compare document counts, with -drift-replications.")
+	cloudStatusInterval         = flag.Duration("cloud-status-interval", 5*time.Minute, "How often to check the IBM Cloud status feed, with -cloud-status-region.")
+	partitionsInterval          = flag.Duration("partitions-interval", 5*time.Minute, "How often to poll partition statistics, with -partitions.")
+)
 
-const failAfter = 5 * time.Minute
+var httpTimeout = flag.Duration("http-timeout", 10*time.Second, "Timeout for each request to Cloudant, eg larger for big _dbs_info responses or slow dedicated clusters.")
+var httpMaxConnsPerHost = flag.Int("http-max-conns-per-host", 10, "The most connections to open to Cloudant at once.")
+var vaultAddr = flag.String("vault.address", os.Getenv("VAULT_ADDR"), "Address of the HashiCorp Vault server to read the API key from, with -vault.secret-path.")
+var vaultSecretPath = flag.String("vault.secret-path", "", "Vault path of the secret holding the API key, eg secret/data/cloudant; {instance} is replaced by the -instances name (default Vault not used).")
+var vaultField = flag.String("vault.field", "apikey", "Field of the Vault secret holding the API key.")
+var vaultRole = flag.String("vault.role", "", "Vault role to log in as with the pod's Kubernetes service account, if VAULT_TOKEN isn't set.")
+var vaultAuthPath = flag.String("vault.auth-path", "kubernetes", "Path of Vault's Kubernetes auth method.")
+var retryMax = flag.Int("retry-max", 3, "How many times to retry a failed request to Cloudant; 0 disables retries.")
+var retryMaxInterval = flag.Duration("retry-max-interval", 30*time.Second, "The longest to wait between retries.")
+var retryStatusCodes = flag.String("retry-status-codes", "", "Comma-separated HTTP status codes to retry (default 429 and 5xx other than 501).")
+var webConfigFile = flag.String("web.config.file", "", "Path of an exporter-toolkit web configuration file, to serve over TLS or with authentication.")
+var webSystemdSocket = flag.Bool("web.systemd-socket", false, "Listen on the sockets passed by systemd socket activation, instead of -listen-address.")
+var logLevel = flag.String("log.level", "info", "Only log messages at this level or above: debug, info, warn or error.")
+var logFormat = flag.String("log.format", "text", "The format of log lines: text or json.")
+var shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "On SIGTERM or SIGINT, how long to wait for scrapes and polls in progress to finish before exiting.")
+var failAfter = flag.Duration("fail-after", 5*time.Minute, "Exit if a monitor fails continuously for this long; 0 never exits, leaving failures to cloudant_exporter_monitor_up.")
+var alertsFor = flag.Duration("alerts.for", 15*time.Minute, "How long a condition must last before its alert, from generate alerts, fires.")
+var alertsReplicationsCrashing = flag.Int("alerts.replications-crashing", 0, "Alert when more replications than this are crashing, with generate alerts.")
+var alertsRateLimitedRatio = flag.Float64("alerts.ratelimited-ratio", 0.05, "Alert when more than this fraction of a request class is rate limited (429), with generate alerts.")
+var alertsIndexLagChanges = flag.Int("alerts.index-lag-changes", 10000, "Alert when a view index is more than this many changes behind its database, with generate alerts.")
+
+var (
+	monitorUp = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_exporter_monitor_up",
+		Help: "Whether the monitor's last poll succeeded (1) or not (0)",
+	},
+		[]string{"monitor"},
+	)
+	monitorLastSuccessTimestamp = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_exporter_monitor_last_success_timestamp_seconds",
+		Help: "The time of the monitor's last successful poll, as a Unix timestamp",
+	},
+		[]string{"monitor"},
+	)
+)
 
 // entry point
 func main() {
-	log.Println(AppName)
-	log.Printf("version %s(%s)", Version, runtime.Version())
+	flag.Var(&findProbes, "find-probe", "A Cloudant Query probe to run, as name,database,selector-json (repeatable).")
+	flag.Var(&viewProbes, "view-probe", "A view probe to run, as name,database/ddoc/view (repeatable).")
+	flag.Var(&searchProbes, "search-probe", "A search probe to run, as name,database/ddoc/index,query (repeatable).")
+	flag.Var(&constLabels, "label", "A constant label to add to every metric, as name=value (repeatable).")
+	flag.Var(&labelDrops, "label-drop", "Labels to drop from the metrics matching a regexp, as metric-regexp=label,label (repeatable).")
+	flag.Var(&labelKeeps, "label-keep", "Labels to keep on the metrics matching a regexp, dropping the rest, as metric-regexp=label,label (repeatable).")
 	flag.Parse()
+	if *showVersion || flag.Arg(0) == "version" {
+		fmt.Printf("%s %s (commit %s, %s)\n", AppName, Version, commit(), runtime.Version())
+		return
+	}
+	if err := setupLogging(); err != nil {
+		fatal("Invalid logging flags", "err", err)
+	}
+	slog.Info(AppName, "version", Version, "commit", commit(), "go", runtime.Version())
+	// note which flags were given on the command line, as
+	// the configuration file mustn't override them
+	cmdline := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		cmdline[f.Name] = true
+	})
+	switch flag.Arg(0) {
+	case "":
+	case "check-config":
+		os.Exit(checkConfig(cmdline))
+	case "list-metrics":
+		if err := loadConfig(cmdline); err != nil {
+			fatal("Could not load -config", "err", err)
+		}
+		if err := listMetrics(os.Stdout); err != nil {
+			fatal("Could not list metrics", "err", err)
+		}
+		return
+	case "generate":
+		if err := loadConfig(cmdline); err != nil {
+			fatal("Could not load -config", "err", err)
+		}
+		if err := generate(os.Stdout, flag.Arg(1)); err != nil {
+			fatal("Could not generate", "err", err)
+		}
+		return
+	case "collect":
+		if err := loadConfig(cmdline); err != nil {
+			fatal("Could not load -config", "err", err)
+		}
+		if _, err := collect(os.Stdout); err != nil {
+			fatal("Could not collect metrics", "err", err)
+		}
+		return
+	default:
+		fatal("Unknown command", "command", flag.Arg(0))
+	}
+	if err := loadConfig(cmdline); err != nil {
+		fatal("Could not load -config", "err", err)
+	}
+
+	if *once {
+		failed, err := collect(os.Stdout)
+		if err != nil {
+			fatal("Could not collect metrics", "err", err)
+		}
+		if len(failed) > 0 {
+			fatal("Monitors failed", "monitors", strings.Join(failed, ", "))
+		}
+		return
+	}
+
+	if names := splitList(*instances); *instanceName == "" && len(names) > 0 {
+		runInstances(names)
+		return
+	}
 
 	cldt, err := newCloudantClient()
 	if err != nil {
-		log.Fatalf("Could not initialise Cloudant client: %v", err)
+		fatal("Could not initialise Cloudant client", "err", err)
 	}
-	userAgent := fmt.Sprintf("%s/%s(%s)", AppName, Version, runtime.Version())
-	cldt.Service.SetUserAgent(userAgent)
+	slog.Info("Using Cloudant", "url", cldt.GetServiceURL())
 
-	log.Printf("Using Cloudant: %s", cldt.GetServiceURL())
+	// cancelled on SIGTERM, abandoning the polls in progress
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	monitors.SetContext(ctx)
 
 	// Monitors publish to this channel if they fail,
 	// typically that they haven't made a successful
-	// request in `failAfter` time.
+	// request in -fail-after time.
 	monitorFailed := make(chan string)
 
-	rc := monitorLooper{
-		Interval: 5 * time.Second,
-		FailBox:  utils.NewFailBox(failAfter),
-		Chk:      &monitors.ReplicationProgressMonitor{Cldt: cldt},
+	// Series scraped from CouchDB's own Prometheus endpoints are
+	// served alongside our own metrics, so the set of gatherers
+	// changes when the monitors are reloaded.
+	gatherer := &reloadableGatherer{}
+	scheduled, gatherers, err := newMonitors(cldt)
+	if err != nil {
+		fatal("Invalid configuration", "err", err)
 	}
-	go func() {
-		rc.Go()
-		monitorFailed <- "ReplicationProgressMonitor"
-	}()
+	gatherer.Set(gatherers)
+	// the monitors' goroutines, including those stopped by a
+	// reload but still polling, so shutdown can wait for them
+	var running sync.WaitGroup
+	stop := startMonitors(ctx, scheduled, monitorFailed, &running)
 
-	rs := monitorLooper{
-		Interval: 10 * time.Minute,
-		FailBox:  utils.NewFailBox(failAfter),
-		Chk:      &monitors.ReplicationStatusMonitor{Cldt: cldt},
+	// reload the configuration file, and restart the monitors with
+	// the new settings. Metrics are kept, so no history is lost.
+	reload := func() error {
+		if err := loadConfig(cmdline); err != nil {
+			return err
+		}
+		scheduled, gatherers, err := newMonitors(cldt)
+		if err != nil {
+			return err
+		}
+		stop()
+		gatherer.Set(gatherers)
+		stop = startMonitors(ctx, scheduled, monitorFailed, &running)
+		slog.Info("Reloaded configuration", "monitors", len(scheduled))
+		return nil
 	}
-	go func() {
-		rs.Go()
-		monitorFailed <- "ReplicationStatusMonitor"
-	}()
+	reloads := make(chan chan error)
 
-	tm := monitorLooper{
-		Interval: 5 * time.Second,
-		FailBox:  utils.NewFailBox(failAfter),
-		Chk:      &monitors.ThroughputMonitor{Cldt: cldt},
+	exported, err := exportedGatherer(gatherer)
+	if err != nil {
+		fatal("Invalid configuration", "err", err)
 	}
-	go func() {
-		tm.Go()
-		monitorFailed <- "ThroughputMonitor"
-	}()
-
-	atm := monitorLooper{
-		Interval: 5 * time.Second,
-		FailBox:  utils.NewFailBox(failAfter),
-		Chk:      &monitors.ActiveTasksMonitor{Cldt: cldt},
+	http.Handle("/metrics", promhttp.InstrumentMetricHandler(
+		prometheus.DefaultRegisterer, promhttp.HandlerFor(exported, promhttp.HandlerOpts{}),
+	))
+	if *probeAllowedTargets != "" {
+		http.HandleFunc("/probe", probeHandler(regexp.MustCompile("^(?:"+*probeAllowedTargets+")$")))
 	}
-	go func() {
-		atm.Go()
-		monitorFailed <- "ActiveTasksMonitor"
-	}()
-
-	http.Handle("/metrics", promhttp.Handler())
+	http.HandleFunc("/-/reload", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			http.Error(w, "Only POST requests allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		// the main loop stops taking reloads once it's shutting down
+		errc := make(chan error)
+		select {
+		case reloads <- errc:
+		case <-ctx.Done():
+			http.Error(w, "Shutting down", http.StatusServiceUnavailable)
+			return
+		}
+		if err := <-errc; err != nil {
+			http.Error(w, fmt.Sprintf("Failed to reload config: %v", err), http.StatusInternalServerError)
+		}
+	})
 	server := &http.Server{
 		Addr:              *addr,
 		ReadHeaderTimeout: 3 * time.Second,
 	}
 	go func() {
-		log.Fatal(server.ListenAndServe())
+		if err := serve(server); !errors.Is(err, http.ErrServerClosed) {
+			fatal("HTTP server failed", "err", err)
+		}
 	}()
-	log.Printf("HTTP server started on %s", *addr)
+	slog.Info("HTTP server started", "address", *addr)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	term := make(chan os.Signal, 1)
+	signal.Notify(term, syscall.SIGTERM, os.Interrupt)
+	for {
+		select {
+		case sig := <-term:
+			slog.Info("Shutting down", "signal", sig)
+			cancel()
+			shutdown(server, &running)
+			return
+		case <-hup:
+			if err := reload(); err != nil {
+				slog.Error("Could not reload configuration", "err", err)
+			}
+		case errc := <-reloads:
+			errc <- reload()
+		case m := <-monitorFailed:
+			// After a monitor fails, we need to shutdown.
+			slog.Error("A monitor died! Exiting.", "monitor", m)
+			// exiting main kills everything
+			return
+		}
+	}
+}
+
+// commit returns Commit, or the VCS revision Go recorded in the
+// binary if that wasn't set.
+func commit() string {
+	if Commit != "" {
+		return Commit
+	}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				return s.Value
+			}
+		}
+	}
+	return "unknown"
+}
+
+// loadConfig applies the -config file, if there is one, to the flags
+// other than those in cmdline, which were given on the command line.
+func loadConfig(cmdline map[string]bool) error {
+	explicit := map[string]bool{}
+	for name := range cmdline {
+		explicit[name] = true
+	}
+	if *configFile != "" {
+		cfg, err := config.Load(*configFile)
+		if err != nil {
+			return err
+		}
+		if err := cfg.SetEnv(); err != nil {
+			return err
+		}
+		config.Reset(flag.CommandLine, cmdline)
+		if err := cfg.Apply(flag.CommandLine, cmdline); err != nil {
+			return err
+		}
+		if names := cfg.InstanceNames(); len(names) > 0 {
+			names = append(splitList(*instances), names...)
+			if err := flag.Set("instances", strings.Join(names, ",")); err != nil {
+				return err
+			}
+		}
+		for name := range cfg.Settings {
+			explicit[name] = true
+		}
+		if *module != "" {
+			if err := cfg.ApplyModule(flag.CommandLine, cmdline, *module); err != nil {
+				return err
+			}
+			for name := range cfg.Modules[*module] {
+				explicit[name] = true
+			}
+		}
+	} else if *module != "" {
+		return fmt.Errorf("-module needs a -config file")
+	}
+	if *disableDefaultCollectors {
+		disableDefaults(explicit)
+	}
+	// the configuration may set the logging flags, or the instance
+	return setupLogging()
+}
+
+// checkConfig validates the configuration file and flags, without
+// connecting to Cloudant, and returns the exit status.
+func checkConfig(cmdline map[string]bool) int {
+	if err := loadConfig(cmdline); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -config %s:\n%v\n", *configFile, err)
+		return 1
+	}
+	if _, _, err := newMonitors(nil); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		return 1
+	}
+	fmt.Println("Configuration OK")
+	return 0
+}
+
+var (
+	metricPrefixRegexp = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelNameRegexp    = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+	instanceNameRegexp = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
+)
+
+// parseLabels parses the -label flags, each name=value.
+func parseLabels(l []string) (map[string]string, error) {
+	labels := map[string]string{}
+	for _, s := range l {
+		name, value, ok := strings.Cut(s, "=")
+		if !ok || !labelNameRegexp.MatchString(name) || strings.HasPrefix(name, "__") {
+			return nil, fmt.Errorf("invalid label %q, expected name=value", s)
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
+
+// exportedGatherer wraps g to rename and label its metrics as the
+// -metric-prefix, -label, -label-drop and -label-keep flags ask.
+func exportedGatherer(g prometheus.Gatherer) (prometheus.Gatherer, error) {
+	labels, err := parseLabels(constLabels)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse -label: %w", err)
+	}
+	rules, err := parseLabelRules(labelDrops, labelKeeps)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse -label-drop or -label-keep: %w", err)
+	}
+	var exported prometheus.Gatherer = &utils.PrefixGatherer{Gatherer: g, Prefix: *metricPrefix}
+	if len(rules) > 0 {
+		exported = &utils.LabelRulesGatherer{Gatherer: exported, Rules: rules}
+	}
+	if len(labels) > 0 {
+		exported = &utils.LabelsGatherer{Gatherer: exported, Labels: labels}
+	}
+	return exported, nil
+}
+
+// parseLabelRules parses the -label-drop and -label-keep flags.
+func parseLabelRules(drops, keeps []string) ([]utils.LabelRule, error) {
+	rules := []utils.LabelRule{}
+	for _, s := range drops {
+		r, err := utils.ParseLabelRule(s, false)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	for _, s := range keeps {
+		r, err := utils.ParseLabelRule(s, true)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, nil
+}
+
+// exportedFamilies returns every metric family the exporter may export,
+// named and labelled as -metric-prefix, -label, -label-drop and
+// -label-keep ask.
+func exportedFamilies() ([]registry.Family, error) {
+	labels, err := parseLabels(constLabels)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse -label: %w", err)
+	}
+	rules, err := parseLabelRules(labelDrops, labelKeeps)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse -label-drop or -label-keep: %w", err)
+	}
+
+	families := registry.Families()
+	for i, f := range families {
+		if strings.HasPrefix(f.Name, utils.DefaultMetricPrefix) {
+			f.Name = *metricPrefix + strings.TrimPrefix(f.Name, utils.DefaultMetricPrefix)
+		}
+		names := map[string]bool{}
+		for _, l := range f.Labels {
+			names[l] = true
+		}
+		for _, r := range rules {
+			if !r.Metric.MatchString(f.Name) {
+				continue
+			}
+			for l := range names {
+				if r.Labels[l] != r.Keep {
+					delete(names, l)
+				}
+			}
+		}
+		for l := range labels {
+			names[l] = true
+		}
+		f.Labels = make([]string, 0, len(names))
+		for l := range names {
+			f.Labels = append(f.Labels, l)
+		}
+		sort.Strings(f.Labels)
+		families[i] = f
+	}
+	// a custom prefix can change the order
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].Name < families[j].Name
+	})
+	return families, nil
+}
+
+// listMetrics writes a table of every metric family the exporter may
+// export, as exportedFamilies returns them.
+func listMetrics(w io.Writer) error {
+	families, err := exportedFamilies()
+	if err != nil {
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tTYPE\tLABELS\tHELP")
+	for _, f := range families {
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", f.Name, f.Type, strings.Join(f.Labels, ","), f.Help)
+	}
+	return tw.Flush()
+}
+
+// validateFlags checks the flags' values make sense together,
+// returning all the problems found.
+func validateFlags() error {
+	errs := []error{}
+	flag.VisitAll(func(f *flag.Flag) {
+		if !strings.HasSuffix(f.Name, "-interval") {
+			return
+		}
+		if d, ok := f.Value.(flag.Getter).Get().(time.Duration); ok && d <= 0 {
+			errs = append(errs, fmt.Errorf("-%s must be positive, got %s", f.Name, d))
+		}
+	})
+	seen := map[string]bool{}
+	for _, name := range splitList(*instances) {
+		if !instanceNameRegexp.MatchString(name) || seen[name] {
+			errs = append(errs, fmt.Errorf("-instances has an invalid or duplicate name %q", name))
+		}
+		seen[name] = true
+	}
+	if *once && *instanceName == "" && len(seen) > 0 {
+		errs = append(errs, fmt.Errorf("-once can't be used with -instances; give -instance-name to check one instance"))
+	}
+	if _, err := regexp.Compile("^(?:" + *probeAllowedTargets + ")$"); err != nil {
+		errs = append(errs, fmt.Errorf("-probe.allowed-targets is not a regexp: %w", err))
+	}
+	if *metricPrefix != "" && !metricPrefixRegexp.MatchString(*metricPrefix) {
+		errs = append(errs, fmt.Errorf("-metric-prefix %q is not a valid metric name prefix", *metricPrefix))
+	}
+	if _, err := parseLabels(constLabels); err != nil {
+		errs = append(errs, fmt.Errorf("could not parse -label: %w", err))
+	}
+	if _, err := parseLabelRules(labelDrops, labelKeeps); err != nil {
+		errs = append(errs, fmt.Errorf("could not parse -label-drop or -label-keep: %w", err))
+	}
+	if *vaultSecretPath != "" && *vaultAddr == "" {
+		errs = append(errs, fmt.Errorf("-vault.secret-path needs -vault.address or VAULT_ADDR"))
+	}
+	if *webConfigFile != "" {
+		if err := web.Validate(*webConfigFile); err != nil {
+			errs = append(errs, fmt.Errorf("invalid -web.config.file: %w", err))
+		}
+	}
+	if *httpTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("-http-timeout must be positive"))
+	}
+	if *httpMaxConnsPerHost <= 0 {
+		errs = append(errs, fmt.Errorf("-http-max-conns-per-host must be positive"))
+	}
+	if *retryMax < 0 {
+		errs = append(errs, fmt.Errorf("-retry-max must not be negative"))
+	}
+	if *retryMaxInterval <= 0 {
+		errs = append(errs, fmt.Errorf("-retry-max-interval must be positive"))
+	}
+	if _, err := parseStatusCodes(*retryStatusCodes); err != nil {
+		errs = append(errs, fmt.Errorf("could not parse -retry-status-codes: %w", err))
+	}
+	if *shutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("-shutdown-timeout must be positive"))
+	}
+	if *failAfter < 0 {
+		errs = append(errs, fmt.Errorf("-fail-after must not be negative"))
+	}
+	if *alertsFor < 0 {
+		errs = append(errs, fmt.Errorf("-alerts.for must not be negative"))
+	}
+	if *alertsReplicationsCrashing < 0 {
+		errs = append(errs, fmt.Errorf("-alerts.replications-crashing must not be negative"))
+	}
+	if *alertsRateLimitedRatio < 0 || *alertsRateLimitedRatio >= 1 {
+		errs = append(errs, fmt.Errorf("-alerts.ratelimited-ratio must be at least 0 and less than 1"))
+	}
+	if *alertsIndexLagChanges < 0 {
+		errs = append(errs, fmt.Errorf("-alerts.index-lag-changes must not be negative"))
+	}
+	if (*replicationProbeSource == "") != (*replicationProbeTarget == "") {
+		errs = append(errs, fmt.Errorf("-replication-probe-source and -replication-probe-target must be given together"))
+	}
+	if *databasesTopN < 0 {
+		errs = append(errs, fmt.Errorf("-databases-top-n must not be negative"))
+	}
+	if *nodeMessageQueuesTopK < 0 {
+		errs = append(errs, fmt.Errorf("-node-message-queues-top-k must not be negative"))
+	}
+	if *replicationStallAfter < 0 {
+		errs = append(errs, fmt.Errorf("-replication-stall-after must not be negative"))
+	}
+	if *cloudStatusRegion != "" {
+		if u, err := url.Parse(*cloudStatusURL); err != nil || u.Host == "" {
+			errs = append(errs, fmt.Errorf("-cloud-status-url %q is not a URL", *cloudStatusURL))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// scheduledMonitor is a monitor and how often it polls.
+type scheduledMonitor struct {
+	interval time.Duration
+	chk      monitor
+}
+
+// newMonitors creates the monitors enabled by the flags, and the
+// gatherers for the metrics they export.
+func newMonitors(cldt *cloudantv1.CloudantV1) ([]scheduledMonitor, prometheus.Gatherers, error) {
+	if err := validateFlags(); err != nil {
+		return nil, nil, err
+	}
+	scheduled := []scheduledMonitor{}
+	add := func(interval time.Duration, chk monitor) {
+		scheduled = append(scheduled, scheduledMonitor{interval: interval, chk: chk})
+	}
+	gatherers := prometheus.Gatherers{prometheus.DefaultGatherer}
+
+	dbFilter, err := monitors.ParseDatabaseFilter(*databasesInclude, *databasesExclude)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not parse -databases.include or -databases.exclude: %w", err)
+	}
+
+	if *collectReplicationProgress {
+		add(*replicationProgressInterval, &monitors.ReplicationProgressMonitor{
+			Cldt:          cldt,
+			ReplicatorDbs: splitList(*replicatorDbs),
+			StallAfter:    *replicationStallAfter,
+		})
+	}
+	if *collectReplicationStatus {
+		add(*replicationStatusInterval, &monitors.ReplicationStatusMonitor{Cldt: cldt, ReplicatorDbs: splitList(*replicatorDbs)})
+	}
+	if *collectSchedulerDocs {
+		add(*schedulerDocsInterval, &monitors.SchedulerDocsMonitor{Cldt: cldt})
+	}
+	if *collectReplicationJobs {
+		add(*replicationJobsInterval, &monitors.ReplicationJobsMonitor{Cldt: cldt})
+	}
+	if *collectThroughput {
+		add(*throughputInterval, &monitors.ThroughputMonitor{Cldt: cldt})
+	}
+	if *collectCapacity {
+		add(*capacityInterval, &monitors.CapacityMonitor{Cldt: cldt})
+	}
+	if *collectCors {
+		add(*corsInterval, &monitors.CorsMonitor{Cldt: cldt})
+	}
+	if *collectSession {
+		add(*sessionInterval, &monitors.SessionMonitor{Cldt: cldt})
+	}
+	if *collectServerInfo {
+		add(*serverInfoInterval, &monitors.ServerInfoMonitor{Cldt: cldt})
+	}
+	if *collectActiveTasks {
+		add(*activeTasksInterval, &monitors.ActiveTasksMonitor{Cldt: cldt})
+	}
+	if *collectDatabases {
+		add(*databasesInterval, &monitors.DatabasesMonitor{Cldt: cldt, Filter: dbFilter, TopN: *databasesTopN})
+	}
+	if *collectDatabaseCount {
+		add(*databaseCountInterval, &monitors.DatabaseCountMonitor{Cldt: cldt})
+	}
+	if *collectDbUpdates {
+		add(*dbUpdatesInterval, &monitors.DbUpdatesMonitor{Cldt: cldt})
+	}
+	if *collectMembership {
+		add(*membershipInterval, &monitors.MembershipMonitor{Cldt: cldt})
+	}
+	if *collectUp {
+		add(*upInterval, &monitors.UpMonitor{Cldt: cldt, PerNode: *nodeUp})
+	}
+	if *collectDesignDocs {
+		add(*designDocsInterval, &monitors.DesignDocsMonitor{Cldt: cldt, Filter: dbFilter})
+	}
+	if *collectMangoIndexes {
+		add(*mangoIndexesInterval, &monitors.MangoIndexesMonitor{Cldt: cldt, Filter: dbFilter})
+	}
+	if *collectSearchIndexes {
+		add(*searchIndexesInterval, &monitors.SearchIndexesMonitor{Cldt: cldt, Filter: dbFilter})
+	}
+	if *collectViewIndexes {
+		add(*viewIndexesInterval, &monitors.ViewIndexesMonitor{Cldt: cldt, Filter: dbFilter})
+	}
+	if *collectShards {
+		add(*shardsInterval, &monitors.ShardsMonitor{Cldt: cldt, Filter: dbFilter})
+	}
+
+	if *nodeStats {
+		add(*nodeStatsInterval, &monitors.NodeStatsMonitor{Cldt: cldt})
+	}
+
+	if *nodeSystem {
+		add(*nodeSystemInterval, &monitors.NodeSystemMonitor{Cldt: cldt, MessageQueuesTopK: *nodeMessageQueuesTopK})
+	}
+
+	if *nodePrometheus {
+		npm := &monitors.NodePrometheusMonitor{Cldt: cldt}
+		gatherers = append(gatherers, npm)
+		add(*nodePrometheusInterval, npm)
+	}
+
+	if *reshard {
+		add(*reshardInterval, &monitors.ReshardMonitor{Cldt: cldt})
+	}
+
+	if *security {
+		add(*securityInterval, &monitors.SecurityMonitor{Cldt: cldt, Filter: dbFilter})
+	}
+
+	if *conflictsDbs != "" {
+		add(*conflictsInterval, &monitors.ConflictsMonitor{Cldt: cldt, Databases: splitList(*conflictsDbs)})
+	}
+
+	if *backupDb != "" {
+		add(*backupsInterval, &monitors.BackupsMonitor{Cldt: cldt, Database: *backupDb})
+	}
+
+	if *canaryDb != "" {
+		add(*canaryInterval, &monitors.CanaryMonitor{Cldt: cldt, Database: *canaryDb})
+	}
+
+	if *replicationProbeSource != "" && *replicationProbeTarget != "" {
+		rauth, err := replicationAuth()
+		if err != nil {
+			return nil, nil, fmt.Errorf("could not configure probe replication: %w", err)
+		}
+		add(*replicationProbeInterval, &monitors.ReplicationProbeMonitor{
+			Cldt:   cldt,
+			Source: *replicationProbeSource,
+			Target: *replicationProbeTarget,
+			Auth:   rauth,
+		})
+	}
+
+	if len(findProbes) > 0 {
+		fps := []monitors.FindProbe{}
+		for _, s := range findProbes {
+			p, err := monitors.ParseFindProbe(s)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not parse -find-probe: %w", err)
+			}
+			fps = append(fps, p)
+		}
+		add(*findProbesInterval, &monitors.FindProbesMonitor{Cldt: cldt, Probes: fps})
+	}
+
+	if len(viewProbes) > 0 {
+		vps := []monitors.ViewProbe{}
+		for _, s := range viewProbes {
+			p, err := monitors.ParseViewProbe(s)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not parse -view-probe: %w", err)
+			}
+			vps = append(vps, p)
+		}
+		add(*viewProbesInterval, &monitors.ViewProbesMonitor{Cldt: cldt, Probes: vps})
+	}
+
+	if len(searchProbes) > 0 {
+		sps := []monitors.SearchProbe{}
+		for _, s := range searchProbes {
+			p, err := monitors.ParseSearchProbe(s)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not parse -search-probe: %w", err)
+			}
+			sps = append(sps, p)
+		}
+		add(*searchProbesInterval, &monitors.SearchProbesMonitor{Cldt: cldt, Probes: sps})
+	}
+
+	if *driftReplications != "" {
+		rps := []monitors.ReplicationPair{}
+		for _, s := range splitList(*driftReplications) {
+			p, err := monitors.ParseReplicationPair(s)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not parse -drift-replications: %w", err)
+			}
+			rps = append(rps, p)
+		}
+		add(*docCountDriftInterval, &monitors.DocCountDriftMonitor{Cldt: cldt, Pairs: rps})
+	}
+
+	if *cloudStatusRegion != "" {
+		add(*cloudStatusInterval, &monitors.CloudStatusMonitor{
+			Client: &http.Client{Timeout: 10 * time.Second},
+			URL:    *cloudStatusURL,
+			Region: *cloudStatusRegion,
+		})
+	}
+
+	if *partitions != "" {
+		ps := []monitors.Partition{}
+		for _, s := range splitList(*partitions) {
+			p, err := monitors.ParsePartition(s)
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not parse -partitions: %w", err)
+			}
+			ps = append(ps, p)
+		}
+		add(*partitionsInterval, &monitors.PartitionsMonitor{Cldt: cldt, Partitions: ps})
+	}
+
+	return scheduled, gatherers, nil
+}
+
+// startMonitors runs each of scheduled in the background, until
+// ctx is done or the returned function is called, adding them to
+// running.
+func startMonitors(ctx context.Context, scheduled []scheduledMonitor, failed chan<- string, running *sync.WaitGroup) context.CancelFunc {
+	ctx, stop := context.WithCancel(ctx)
+	for _, m := range scheduled {
+		startMonitor(ctx, m.interval, m.chk, failed, running)
+	}
+	return stop
+}
 
-	// After a monitor fails, we need to shutdown.
-	m := <-monitorFailed
-	log.Printf("A monitor died: %q! Exiting.", m)
-	// exiting main kills everything
+// shutdown stops server accepting scrapes, and waits for those in
+// progress, and the monitors' polls in progress, to finish, for up
+// to -shutdown-timeout. Anything still running is then abandoned.
+func shutdown(server *http.Server, running *sync.WaitGroup) {
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		slog.Warn("Could not finish serving scrapes", "err", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		slog.Info("Shut down")
+	case <-ctx.Done():
+		slog.Warn("Timed out waiting for polls to finish; abandoning them")
+	}
 }
 
 // newCloudantClient creates a new client for Cloudant, configured
 // from environment variables, with a safe HTTP client.
 func newCloudantClient() (*cloudantv1.CloudantV1, error) {
+	if err := config.LoadSecretFiles(config.ServiceName(*instanceName)); err != nil {
+		return nil, err
+	}
+	if *vaultSecretPath != "" {
+		if err := loadVaultAPIKey(); err != nil {
+			return nil, fmt.Errorf("could not read API key from Vault: %w", err)
+		}
+	}
 
 	// connect to Cloudant
 	service, err := cloudantv1.NewCloudantV1UsingExternalConfig(
 		&cloudantv1.CloudantV1Options{
-			ServiceName: "CLOUDANT",
+			ServiceName: config.ServiceName(*instanceName),
 		},
 	)
 	if err != nil {
@@ -115,62 +938,254 @@ func newCloudantClient() (*cloudantv1.CloudantV1, error) {
 
 	t := http.DefaultTransport.(*http.Transport).Clone()
 	t.MaxIdleConns = 100
-	t.MaxConnsPerHost = 10
-	t.MaxIdleConnsPerHost = 10
+	t.MaxConnsPerHost = *httpMaxConnsPerHost
+	t.MaxIdleConnsPerHost = *httpMaxConnsPerHost
 	c := &http.Client{
-		Timeout:   10 * time.Second,
-		Transport: t,
+		Timeout:   *httpTimeout,
+		Transport: ratelimit.NewTransport(t),
 	}
 	service.Service.SetHTTPClient(c)
+	service.Service.SetUserAgent(fmt.Sprintf("%s/%s(%s)", AppName, Version, runtime.Version()))
 
-	service.EnableRetries(3, 30*time.Second)
+	// Instrument IAM so that token problems show up in metrics
+	if iam, ok := service.Service.Options.Authenticator.(*core.IamAuthenticator); ok {
+		service.Service.Options.Authenticator = auth.NewInstrumentedIamAuthenticator(iam)
+	}
+
+	if *retryMax > 0 {
+		service.EnableRetries(*retryMax, *retryMaxInterval)
+		if *retryStatusCodes != "" {
+			codes, err := parseStatusCodes(*retryStatusCodes)
+			if err != nil {
+				return nil, fmt.Errorf("could not parse -retry-status-codes: %w", err)
+			}
+			rt := service.Service.Client.Transport.(*retryablehttp.RoundTripper)
+			rt.Client.CheckRetry = retryPolicy(codes)
+		}
+	}
 
 	return service, nil
 }
 
+// loadVaultAPIKey sets the API key environment variable from Vault,
+// and keeps the Vault token and secret lease renewed in the background.
+func loadVaultAPIKey() error {
+	name := config.ServiceName(*instanceName) + "_APIKEY"
+	if _, ok := os.LookupEnv(name); ok {
+		return fmt.Errorf("both %s and -vault.secret-path are set", name)
+	}
+	vc := &vault.Client{
+		Addr:     *vaultAddr,
+		AuthPath: *vaultAuthPath,
+		Role:     *vaultRole,
+		HTTP:     &http.Client{Timeout: *httpTimeout},
+	}
+	if err := vc.Login(); err != nil {
+		return err
+	}
+	path := strings.ReplaceAll(*vaultSecretPath, "{instance}", *instanceName)
+	apiKey, err := vc.Read(path, *vaultField)
+	if err != nil {
+		return err
+	}
+	if err := os.Setenv(name, apiKey); err != nil {
+		return err
+	}
+	go vc.Renew()
+	slog.Info("Read API key from Vault", "path", path)
+	return nil
+}
+
+// retryPolicy retries the responses with one of codes, and the same
+// errors as the SDK's default policy, eg a connection being reset.
+func retryPolicy(codes map[int]bool) retryablehttp.CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		if ctx.Err() != nil || err != nil {
+			return core.IBMCloudSDKRetryPolicy(ctx, resp, err)
+		}
+		return codes[resp.StatusCode], nil
+	}
+}
+
+// parseStatusCodes parses a comma-separated list of HTTP status codes.
+func parseStatusCodes(s string) (map[int]bool, error) {
+	codes := map[int]bool{}
+	for _, c := range splitList(s) {
+		code, err := strconv.Atoi(c)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("invalid HTTP status code %q", c)
+		}
+		codes[code] = true
+	}
+	return codes, nil
+}
+
+// replicationAuth returns the authentication for the replicator to use,
+// from the same environment variables as the Cloudant client.
+func replicationAuth() (*cloudantv1.ReplicationDatabaseAuth, error) {
+	props, err := core.GetServiceProperties(config.ServiceName(*instanceName))
+	if err != nil {
+		return nil, err
+	}
+	if apiKey := props[core.PROPNAME_APIKEY]; apiKey != "" {
+		return &cloudantv1.ReplicationDatabaseAuth{
+			Iam: &cloudantv1.ReplicationDatabaseAuthIam{ApiKey: &apiKey},
+		}, nil
+	}
+	username, password := props[core.PROPNAME_USERNAME], props[core.PROPNAME_PASSWORD]
+	if username != "" {
+		return &cloudantv1.ReplicationDatabaseAuth{
+			Basic: &cloudantv1.ReplicationDatabaseAuthBasic{Username: &username, Password: &password},
+		}, nil
+	}
+	return nil, fmt.Errorf("no API key or username found for the replicator to use")
+}
+
+// splitList splits a comma-separated flag value into its
+// trimmed, non-empty elements.
+func splitList(s string) []string {
+	l := []string{}
+	for _, e := range strings.Split(s, ",") {
+		if e = strings.TrimSpace(e); e != "" {
+			l = append(l, e)
+		}
+	}
+	return l
+}
+
+// collectorFlag defines the flag enabling the default collector name.
+func collectorFlag(name string) *bool {
+	enabled := flag.Bool("collector."+name, true, fmt.Sprintf("Enable the %s collector.", name))
+	collectors[name] = enabled
+	return enabled
+}
+
+// optInCollectorFlag defines the flag enabling the collector name,
+// which is off unless turned on.
+func optInCollectorFlag(name string) *bool {
+	return flag.Bool("collector."+name, false, fmt.Sprintf("Enable the %s collector.", name))
+}
+
+// disableDefaults turns off the default collectors which weren't
+// explicitly turned on by a flag or the configuration file.
+func disableDefaults(explicit map[string]bool) {
+	for name, enabled := range collectors {
+		if !explicit["collector."+name] {
+			*enabled = false
+		}
+	}
+}
+
+// startMonitor runs chk every interval in the background, until ctx
+// is done, sending its name to failed if it fails for longer than
+// -fail-after, or two intervals if that's longer.
+func startMonitor(ctx context.Context, interval time.Duration, chk monitor, failed chan<- string, running *sync.WaitGroup) {
+	// otherwise a monitor polling less often than -fail-after would
+	// exit on its first failure
+	after := *failAfter
+	if after > 0 {
+		after = max(after, 2*interval)
+	}
+	l := monitorLooper{
+		Interval: interval,
+		FailBox:  utils.NewFailBox(after),
+		Chk:      chk,
+		Ctx:      ctx,
+	}
+	running.Add(1)
+	go func() {
+		l.Go()
+		running.Done()
+		select {
+		case <-ctx.Done():
+		default:
+			failed <- chk.Name()
+		}
+	}()
+}
+
+// reloadableGatherer gathers from the current set of gatherers,
+// which is replaced when the monitors are reloaded.
+type reloadableGatherer struct {
+	mu        sync.Mutex
+	gatherers prometheus.Gatherers
+}
+
+func (rg *reloadableGatherer) Set(g prometheus.Gatherers) {
+	rg.mu.Lock()
+	defer rg.mu.Unlock()
+	rg.gatherers = g
+}
+
+func (rg *reloadableGatherer) Gather() ([]*dto.MetricFamily, error) {
+	rg.mu.Lock()
+	g := rg.gatherers
+	rg.mu.Unlock()
+	return g.Gather()
+}
+
 type monitor interface {
 	Retrieve() error
 	Name() string
 }
 
 // monitorLooper runs Chk every Interval, using FailBox to decide when to give up and exit
-// on receiving errors.
+// on receiving errors. It also stops when Ctx is done.
 type monitorLooper struct {
 	Interval time.Duration
 	FailBox  *utils.FailBox
 	Chk      monitor
+	Ctx      context.Context
 }
 
 func (rc *monitorLooper) Go() {
 	// do the first poll straight after a random pause, and at
 	// regular intervals thereafter
 	offset := rand.Intn(15) //nolint:gosec,gomnd // math/rand is good enough for this use-case
-	time.Sleep(time.Duration(offset * int(time.Second)))
-	log.Printf("[%s] startup tick (+%d s)", rc.Chk.Name(), offset)
-	err := rc.Chk.Retrieve()
-	if err != nil {
-		log.Printf("[%s] error getting tasks: %v; last success: %s", rc.Chk.Name(), err, rc.FailBox.LastSuccess())
-		rc.FailBox.Failure()
-	} else {
-		rc.FailBox.Success()
+	select {
+	case <-time.After(time.Duration(offset * int(time.Second))):
+	case <-rc.Ctx.Done():
+		return
 	}
+	slog.Debug("Startup tick", "monitor", rc.Chk.Name(), "offset_seconds", offset)
+	rc.retrieve()
 
 	ticker := time.NewTicker(rc.Interval)
-	for range ticker.C {
-		log.Printf("[%s] tick", rc.Chk.Name())
-		err := rc.Chk.Retrieve()
-
-		// Exit the monitor if we've not been successful for 20 minutes
-		if err != nil {
-			log.Printf("[%s] error getting tasks: %v; last success: %s", rc.Chk.Name(), err, rc.FailBox.LastSuccess())
-			rc.FailBox.Failure()
-		} else {
-			rc.FailBox.Success()
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-rc.Ctx.Done():
+			slog.Debug("Stopping", "monitor", rc.Chk.Name())
+			return
 		}
+		slog.Debug("Tick", "monitor", rc.Chk.Name())
+		rc.retrieve()
 
+		// Exit the monitor if we've not been successful for -fail-after
 		if rc.FailBox.ShouldExit() {
-			log.Printf("[%s] exiting; >%s since last success at %s", rc.Chk.Name(), failAfter, rc.FailBox.LastSuccess())
+			slog.Error("Exiting, failing for too long", "monitor", rc.Chk.Name(), "fail_after", rc.FailBox.FailAfter().String(), "last_success", rc.FailBox.LastSuccess())
 			return
 		}
 	}
 }
+
+// retrieve polls Chk once, recording the outcome in FailBox and
+// the monitor's metrics, and returns whether it succeeded.
+func (rc *monitorLooper) retrieve() bool {
+	name := rc.Chk.Name()
+	start := time.Now()
+	err := rc.Chk.Retrieve()
+	duration := time.Since(start)
+	if err != nil {
+		slog.Error("Poll failed", "monitor", name, "duration_seconds", duration.Seconds(), "err", err, "last_success", rc.FailBox.LastSuccess())
+		rc.FailBox.Failure()
+		monitorUp.WithLabelValues(name).Set(0)
+		return false
+	}
+	rc.FailBox.Success()
+	monitorUp.WithLabelValues(name).Set(1)
+	monitorLastSuccessTimestamp.WithLabelValues(name).Set(float64(rc.FailBox.LastSuccess().Unix()))
+	slog.Debug("Poll succeeded", "monitor", name, "duration_seconds", duration.Seconds())
+	return true
+}
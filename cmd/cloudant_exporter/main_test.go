@@ -0,0 +1,32 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestExportedFamilies(t *testing.T) {
+	families, err := exportedFamilies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := map[string]bool{}
+	for _, f := range families {
+		names[f.Name] = true
+		// metrics created without registry.Auto have no type until
+		// they have series
+		if strings.HasPrefix(f.Name, "cloudant_") && f.Type == "untyped" {
+			t.Errorf("%s is untyped; create it with registry.Auto or registry.Register", f.Name)
+		}
+	}
+	for _, name := range []string{
+		"cloudant_exporter_monitor_up",
+		"cloudant_iam_token_expiry_seconds",
+		"cloudant_replication_docs_read_total",
+		"go_goroutines",
+	} {
+		if !names[name] {
+			t.Errorf("%s is not listed", name)
+		}
+	}
+}
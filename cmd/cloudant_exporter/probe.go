@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloudant.com/cloudant_exporter/internal/config"
+	"cloudant.com/cloudant_exporter/internal/utils"
+	"github.com/prometheus/common/expfmt"
+)
+
+// defaultProbeTimeout limits a probe when Prometheus doesn't
+// say how long it will wait.
+const defaultProbeTimeout = 1 * time.Minute
+
+// probeHandler serves /probe?target=<url>&module=<name>, monitoring the
+// Cloudant instance at target, if it matches allowed, with the settings
+// of the module. As the monitors' metrics belong to the process, each
+// probe runs an exporter process with the collect command, which runs
+// the monitors once against the target, and serves what it prints.
+func probeHandler(allowed *regexp.Regexp) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target := r.URL.Query().Get("target")
+		if u, err := url.Parse(target); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			http.Error(w, fmt.Sprintf("Target %q is not a URL", target), http.StatusBadRequest)
+			return
+		}
+		if !allowed.MatchString(target) {
+			http.Error(w, fmt.Sprintf("Target %q is not allowed", target), http.StatusForbidden)
+			return
+		}
+		module := r.URL.Query().Get("module")
+
+		timeout := defaultProbeTimeout
+		if s := r.Header.Get("X-Prometheus-Scrape-Timeout-Seconds"); s != "" {
+			if secs, err := strconv.ParseFloat(s, 64); err == nil && secs > 0 {
+				timeout = time.Duration(secs * float64(time.Second))
+			}
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+
+		out, err := probe(ctx, target, module)
+		if err != nil {
+			slog.Error("Probe failed", "target", redact(target), "module", module, "err", err)
+			http.Error(w, fmt.Sprintf("Probe failed: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", string(expfmt.FmtText))
+		_, _ = w.Write(out)
+	}
+}
+
+// probe runs the collect command against target, returning its output.
+func probe(ctx context.Context, target, module string) ([]byte, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return nil, err
+	}
+	// later flags override earlier ones; we've already read the API
+	// key from Vault, if it's used, into the environment
+	args := append([]string{}, os.Args[1:]...)
+	args = append(args, "-vault.secret-path", "")
+	if module != "" {
+		args = append(args, "-module", module)
+	}
+	args = append(args, "collect")
+
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Env = probeEnv(os.Environ(), config.ServiceName(*instanceName), target)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// probeEnv returns the environment for the collect process probing
+// target, from ours. The credentials were read from any _FILE or Vault
+// inputs into the service's variables, so it leaves those out, as the
+// process would refuse to start with both.
+func probeEnv(environ []string, service, target string) []string {
+	env := []string{}
+	for _, kv := range environ {
+		name, _, _ := strings.Cut(kv, "=")
+		if name == service+"_URL" || strings.HasPrefix(name, "VAULT_") {
+			continue
+		}
+		if strings.HasPrefix(name, service+"_") && strings.HasSuffix(name, "_FILE") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	return append(env, service+"_URL="+target)
+}
+
+// redact drops any credentials from a URL, for logging.
+func redact(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Redacted()
+}
+
+// collect runs each enabled monitor once, at the same time, and writes
+// the resulting metrics to w in the text exposition format. It returns
+// the names of the monitors which failed.
+func collect(w io.Writer) ([]string, error) {
+	cldt, err := newCloudantClient()
+	if err != nil {
+		return nil, fmt.Errorf("could not initialise Cloudant client: %w", err)
+	}
+	scheduled, gatherers, err := newMonitors(cldt)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed []string
+	)
+	for _, m := range scheduled {
+		wg.Add(1)
+		go func(chk monitor) {
+			defer wg.Done()
+			l := monitorLooper{FailBox: utils.NewFailBox(*failAfter), Chk: chk}
+			if !l.retrieve() {
+				mu.Lock()
+				failed = append(failed, chk.Name())
+				mu.Unlock()
+			}
+		}(m.chk)
+	}
+	wg.Wait()
+	sort.Strings(failed)
+
+	exported, err := exportedGatherer(gatherers)
+	if err != nil {
+		return nil, err
+	}
+	mfs, err := exported.Gather()
+	if err != nil {
+		return nil, err
+	}
+	enc := expfmt.NewEncoder(w, expfmt.FmtText)
+	for _, mf := range mfs {
+		if err := enc.Encode(mf); err != nil {
+			return nil, err
+		}
+	}
+	return failed, nil
+}
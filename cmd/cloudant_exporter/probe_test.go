@@ -0,0 +1,26 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestProbeEnv(t *testing.T) {
+	environ := []string{
+		"PATH=/usr/bin",
+		"CLOUDANT_URL=https://self.cloudant.com",
+		"CLOUDANT_APIKEY=secret",
+		"CLOUDANT_APIKEY_FILE=/run/secrets/apikey",
+		"VAULT_ADDR=https://vault.example.com:8200",
+		"VAULT_TOKEN=token",
+	}
+	got := probeEnv(environ, "CLOUDANT", "https://other.cloudant.com")
+	want := []string{
+		"PATH=/usr/bin",
+		"CLOUDANT_APIKEY=secret",
+		"CLOUDANT_URL=https://other.cloudant.com",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
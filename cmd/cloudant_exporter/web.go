@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"github.com/prometheus/exporter-toolkit/web"
+)
+
+// serve serves HTTP on the server's address, or with -web.systemd-socket
+// on the sockets systemd passes us, with the TLS and authentication the
+// -web.config.file asks for, if any. The file is read for each
+// connection, so changes apply straight away.
+func serve(server *http.Server) error {
+	addrs := []string{server.Addr}
+	return web.ListenAndServe(server, &web.FlagConfig{
+		WebListenAddresses: &addrs,
+		WebSystemdSocket:   webSystemdSocket,
+		WebConfigFile:      webConfigFile,
+	}, kitLogger{})
+}
+
+// kitLogger logs the exporter toolkit's go-kit log lines with slog.
+type kitLogger struct{}
+
+func (kitLogger) Log(keyvals ...interface{}) error {
+	level, msg := slog.LevelInfo, ""
+	attrs := []interface{}{}
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		key := fmt.Sprint(keyvals[i])
+		switch key {
+		case "level":
+			_ = level.UnmarshalText([]byte(fmt.Sprint(keyvals[i+1])))
+		case "msg":
+			msg = fmt.Sprint(keyvals[i+1])
+		default:
+			attrs = append(attrs, key, keyvals[i+1])
+		}
+	}
+	slog.Log(context.Background(), level, msg, attrs...)
+	return nil
+}
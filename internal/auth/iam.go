@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/IBM/go-sdk-core/v5/core"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// InstrumentedIamAuthenticator wraps the SDK's IamAuthenticator to
+// export metrics about the health of the IAM token, so that failures
+// to refresh it are visible before every monitor starts failing.
+type InstrumentedIamAuthenticator struct {
+	*core.IamAuthenticator
+}
+
+var errInvalidToken = errors.New("token does not have three segments")
+
+// iamTokenExpiry is the expiry of the latest token used, as unix
+// seconds, or 0 before the first.
+var iamTokenExpiry atomic.Int64
+
+var (
+	iamTokenRefreshFailures = registry.Auto.NewCounter(prometheus.CounterOpts{
+		Name: "cloudant_iam_token_refresh_failures_total",
+		Help: "The number of times the exporter failed to get an IAM token",
+	})
+	_ = registry.Auto.NewGaugeFunc(prometheus.GaugeOpts{
+		Name: "cloudant_iam_token_expiry_seconds",
+		Help: "Seconds until the current IAM token expires (negative once expired)",
+	}, func() float64 {
+		exp := iamTokenExpiry.Load()
+		if exp == 0 {
+			return 0
+		}
+		return float64(exp - time.Now().Unix())
+	})
+)
+
+// NewInstrumentedIamAuthenticator wraps a.
+func NewInstrumentedIamAuthenticator(a *core.IamAuthenticator) *InstrumentedIamAuthenticator {
+	return &InstrumentedIamAuthenticator{IamAuthenticator: a}
+}
+
+// Authenticate implements core.Authenticator, recording whether
+// we were able to get a token, and when that token expires.
+func (ia *InstrumentedIamAuthenticator) Authenticate(request *http.Request) error {
+	token, err := ia.GetToken()
+	if err != nil {
+		iamTokenRefreshFailures.Inc()
+		return err
+	}
+
+	if exp, err := tokenExpiry(token); err == nil {
+		iamTokenExpiry.Store(exp)
+	}
+
+	request.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// tokenExpiry returns the "exp" claim of the JWT token.
+func tokenExpiry(token string) (int64, error) {
+	segments := strings.Split(token, ".")
+	if len(segments) != 3 {
+		return 0, errInvalidToken
+	}
+	claimBytes, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(segments[1], "="))
+	if err != nil {
+		return 0, err
+	}
+	claims := struct {
+		ExpiresAt int64 `json:"exp"`
+	}{}
+	if err := json.Unmarshal(claimBytes, &claims); err != nil {
+		return 0, err
+	}
+	return claims.ExpiresAt, nil
+}
@@ -0,0 +1,283 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the contents of the YAML configuration file. Settings can be
+// any of the command line flags, by name, eg:
+//
+//	cloudant:
+//	  url: https://myservice.cloudant.com
+//	  apikey: my_IAM_API_KEY
+//	settings:
+//	  node-stats: true
+//	  replicator-databases: [_replicator, other/_replicator]
+//
+// Environment variables override the connection details, and flags
+// given on the command line override the settings. Instances lists
+// further Cloudant instances to monitor from the same exporter, and
+// Modules names sets of settings to apply on top of Settings, eg for
+// different kinds of /probe target.
+type Config struct {
+	Cloudant  Connection                        `yaml:"cloudant"`
+	Instances []Instance                        `yaml:"instances"`
+	Settings  map[string]interface{}            `yaml:"settings"`
+	Modules   map[string]map[string]interface{} `yaml:"modules"`
+
+	// lines holds the line in the file of each setting, for errors.
+	lines map[string]int
+}
+
+// Connection holds the Cloudant connection details, which are
+// passed to the SDK as its CLOUDANT_* environment variables.
+//
+// The *_file variants name files holding the value, eg a mounted
+// Kubernetes or Docker secret, read by LoadSecretFiles.
+type Connection struct {
+	URL          string `yaml:"url"`
+	AuthType     string `yaml:"auth_type"`
+	APIKey       string `yaml:"apikey"`
+	APIKeyFile   string `yaml:"apikey_file"`
+	Username     string `yaml:"username"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+}
+
+// Instance is a named Cloudant instance, whose connection details are
+// passed to the SDK as its CLOUDANT_<NAME>_* environment variables.
+type Instance struct {
+	Name       string `yaml:"name"`
+	Connection `yaml:",inline"`
+}
+
+// ServiceName returns the SDK service name, and so the environment
+// variable prefix, for the named instance, eg CLOUDANT_ORDERS for
+// "orders". The default, unnamed instance is just CLOUDANT.
+func ServiceName(instance string) string {
+	if instance == "" {
+		return "CLOUDANT"
+	}
+	return "CLOUDANT_" + strings.ToUpper(strings.ReplaceAll(instance, "-", "_"))
+}
+
+// InstanceNames returns the names of the instances in the file.
+func (c *Config) InstanceNames() []string {
+	names := make([]string, 0, len(c.Instances))
+	for _, i := range c.Instances {
+		names = append(names, i.Name)
+	}
+	return names
+}
+
+// Load reads the configuration file at path.
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &Config{}
+	if err := yaml.Unmarshal(b, cfg); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+
+	var doc struct {
+		Settings yaml.Node `yaml:"settings"`
+	}
+	if err := yaml.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("could not parse %s: %w", path, err)
+	}
+	cfg.lines = map[string]int{}
+	if doc.Settings.Kind == yaml.MappingNode {
+		for i := 0; i+1 < len(doc.Settings.Content); i += 2 {
+			key := doc.Settings.Content[i]
+			cfg.lines[key.Value] = key.Line
+		}
+	}
+	return cfg, nil
+}
+
+// SetEnv sets the SDK's environment variables from the connection
+// details of the default instance and each named instance, unless
+// they are already set.
+func (c *Config) SetEnv() error {
+	if err := c.Cloudant.setEnv(ServiceName("")); err != nil {
+		return err
+	}
+	for _, i := range c.Instances {
+		if i.Name == "" {
+			return fmt.Errorf("instance with no name")
+		}
+		if err := i.setEnv(ServiceName(i.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (conn *Connection) setEnv(service string) error {
+	for suffix, value := range map[string]string{
+		"_URL":           conn.URL,
+		"_AUTH_TYPE":     conn.AuthType,
+		"_APIKEY":        conn.APIKey,
+		"_APIKEY_FILE":   conn.APIKeyFile,
+		"_USERNAME":      conn.Username,
+		"_PASSWORD":      conn.Password,
+		"_PASSWORD_FILE": conn.PasswordFile,
+	} {
+		name := service + suffix
+		if _, ok := os.LookupEnv(name); ok || value == "" {
+			continue
+		}
+		if err := os.Setenv(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Apply sets the flags in fs from the settings, other than those
+// in cmdline, which were given on the command line. A list sets a
+// RepeatedFlag once per element, and any other flag to the
+// comma-separated elements. All the invalid settings are reported,
+// in the order they appear in the file.
+func (c *Config) Apply(fs *flag.FlagSet, cmdline map[string]bool) error {
+	return applySettings(fs, cmdline, c.Settings, c.lines)
+}
+
+// ApplyModule sets the flags in fs from the named module's settings,
+// as Apply does.
+func (c *Config) ApplyModule(fs *flag.FlagSet, cmdline map[string]bool, module string) error {
+	settings, ok := c.Modules[module]
+	if !ok {
+		return fmt.Errorf("unknown module %q", module)
+	}
+	if err := applySettings(fs, cmdline, settings, nil); err != nil {
+		return fmt.Errorf("module %q: %w", module, err)
+	}
+	return nil
+}
+
+func applySettings(fs *flag.FlagSet, cmdline map[string]bool, settings map[string]interface{}, lines map[string]int) error {
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if lines[names[i]] != lines[names[j]] {
+			return lines[names[i]] < lines[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	errs := []error{}
+	for _, name := range names {
+		if err := applySetting(fs, cmdline, name, settings[name]); err != nil {
+			if line, ok := lines[name]; ok {
+				err = fmt.Errorf("line %d: %w", line, err)
+			}
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func applySetting(fs *flag.FlagSet, cmdline map[string]bool, name string, value interface{}) error {
+	f := fs.Lookup(name)
+	if f == nil {
+		return fmt.Errorf("unknown setting %q", name)
+	}
+	if cmdline[name] {
+		return nil
+	}
+
+	list, isList := value.([]interface{})
+	if !isList {
+		if err := fs.Set(name, fmt.Sprint(value)); err != nil {
+			return fmt.Errorf("invalid setting %q: %w", name, err)
+		}
+		return nil
+	}
+	values := make([]string, 0, len(list))
+	for _, v := range list {
+		values = append(values, fmt.Sprint(v))
+	}
+	if _, ok := f.Value.(*RepeatedFlag); ok {
+		for _, v := range values {
+			if err := fs.Set(name, v); err != nil {
+				return fmt.Errorf("invalid setting %q: %w", name, err)
+			}
+		}
+	} else if err := fs.Set(name, strings.Join(values, ",")); err != nil {
+		return fmt.Errorf("invalid setting %q: %w", name, err)
+	}
+	return nil
+}
+
+// secretVariables are the SDK's environment variables, without the
+// service name prefix, that can be read from a file named by the
+// variable with a _FILE suffix.
+var secretVariables = []string{"_APIKEY", "_USERNAME", "_PASSWORD"}
+
+// LoadSecretFiles sets the service's environment variables, eg
+// CLOUDANT_APIKEY, from the files named by their _FILE variants, eg
+// CLOUDANT_APIKEY_FILE, ignoring any trailing newline. It unsets the
+// _FILE variants, so child processes don't see both.
+func LoadSecretFiles(service string) error {
+	for _, suffix := range secretVariables {
+		name := service + suffix
+		path, ok := os.LookupEnv(name + "_FILE")
+		if !ok {
+			continue
+		}
+		if _, ok := os.LookupEnv(name); ok {
+			return fmt.Errorf("both %s and %s_FILE are set", name, name)
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("could not read %s_FILE: %w", name, err)
+		}
+		if err := os.Setenv(name, strings.TrimRight(string(b), "\r\n")); err != nil {
+			return err
+		}
+		if err := os.Unsetenv(name + "_FILE"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reset returns the flags in fs, other than those in cmdline, to their
+// defaults, so that settings removed from the file no longer apply.
+func Reset(fs *flag.FlagSet, cmdline map[string]bool) {
+	fs.VisitAll(func(f *flag.Flag) {
+		if cmdline[f.Name] {
+			return
+		}
+		if r, ok := f.Value.(*RepeatedFlag); ok {
+			*r = nil
+			return
+		}
+		// the defaults are valid values, so this can't fail
+		_ = f.Value.Set(f.DefValue)
+	})
+}
+
+// RepeatedFlag collects the values of a flag that can be given many times.
+type RepeatedFlag []string
+
+func (f *RepeatedFlag) String() string {
+	return strings.Join(*f, " ")
+}
+
+func (f *RepeatedFlag) Set(v string) error {
+	*f = append(*f, v)
+	return nil
+}
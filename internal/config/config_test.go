@@ -0,0 +1,217 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeConfig writes a configuration file holding yaml, returning its path.
+func writeConfig(t *testing.T, yaml string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// testFlags returns a flag set like the exporter's, and its flags' values.
+func testFlags() (*flag.FlagSet, *string, *bool, *string, *RepeatedFlag) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	addr := fs.String("listen-address", ":8080", "")
+	nodeStats := fs.Bool("node-stats", false, "")
+	dbs := fs.String("replicator-databases", "", "")
+	probes := new(RepeatedFlag)
+	fs.Var(probes, "view-probe", "")
+	return fs, addr, nodeStats, dbs, probes
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name      string
+		yaml      string
+		cmdline   []string
+		addr      string
+		nodeStats bool
+		dbs       string
+		probes    RepeatedFlag
+		err       string
+	}{
+		{
+			name: "defaults",
+			yaml: "settings:\n",
+			addr: ":8080",
+		},
+		{
+			name:      "settings",
+			yaml:      "settings:\n  listen-address: :9100\n  node-stats: true\n",
+			addr:      ":9100",
+			nodeStats: true,
+		},
+		{
+			name: "list joined with commas",
+			yaml: "settings:\n  replicator-databases: [_replicator, other/_replicator]\n",
+			addr: ":8080",
+			dbs:  "_replicator,other/_replicator",
+		},
+		{
+			name:   "list repeats repeated flag",
+			yaml:   "settings:\n  view-probe:\n    - a,db/ddoc/view\n    - b,db/ddoc/view\n",
+			addr:   ":8080",
+			probes: RepeatedFlag{"a,db/ddoc/view", "b,db/ddoc/view"},
+		},
+		{
+			name:    "command line wins",
+			yaml:    "settings:\n  listen-address: :9100\n  node-stats: true\n",
+			cmdline: []string{"-listen-address", ":9200"},
+			addr:    ":9200",
+			// settings not on the command line still apply
+			nodeStats: true,
+		},
+		{
+			name: "unknown setting",
+			yaml: "settings:\n  listen-address: :9100\n  no-such-flag: 1\n",
+			addr: ":9100",
+			err:  `line 3: unknown setting "no-such-flag"`,
+		},
+		{
+			name: "errors in file order",
+			yaml: "settings:\n  node-stats: maybe\n  listen-address: :9100\n  bogus: 1\n",
+			addr: ":9100",
+			err:  "line 2: invalid setting \"node-stats\": parse error\nline 4: unknown setting \"bogus\"",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fs, addr, nodeStats, dbs, probes := testFlags()
+			if err := fs.Parse(tt.cmdline); err != nil {
+				t.Fatal(err)
+			}
+			cmdline := map[string]bool{}
+			fs.Visit(func(f *flag.Flag) { cmdline[f.Name] = true })
+
+			cfg, err := Load(writeConfig(t, tt.yaml))
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = cfg.Apply(fs, cmdline)
+			if tt.err == "" && err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+			if tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("got error %v, want %q", err, tt.err)
+			}
+			if *addr != tt.addr {
+				t.Errorf("listen-address = %q, want %q", *addr, tt.addr)
+			}
+			if *nodeStats != tt.nodeStats {
+				t.Errorf("node-stats = %v, want %v", *nodeStats, tt.nodeStats)
+			}
+			if *dbs != tt.dbs {
+				t.Errorf("replicator-databases = %q, want %q", *dbs, tt.dbs)
+			}
+			if !reflect.DeepEqual(*probes, tt.probes) {
+				t.Errorf("view-probe = %q, want %q", *probes, tt.probes)
+			}
+		})
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	if _, err := Load(writeConfig(t, "settings: [")); err == nil {
+		t.Error("expected an error parsing invalid YAML")
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("expected an error loading a missing file")
+	}
+}
+
+func TestReset(t *testing.T) {
+	fs, addr, nodeStats, _, probes := testFlags()
+	if err := fs.Parse([]string{"-listen-address", ":9200"}); err != nil {
+		t.Fatal(err)
+	}
+	cmdline := map[string]bool{"listen-address": true}
+	cfg, err := Load(writeConfig(t, "settings:\n  node-stats: true\n  view-probe: [\"a,db/ddoc/view\"]\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Apply(fs, cmdline); err != nil {
+		t.Fatal(err)
+	}
+
+	// as if the settings had been removed from the file on reload
+	Reset(fs, cmdline)
+	if *nodeStats {
+		t.Error("node-stats was not reset")
+	}
+	if len(*probes) != 0 {
+		t.Errorf("view-probe = %q, want none", *probes)
+	}
+	if *addr != ":9200" {
+		t.Errorf("listen-address = %q, want the command line's :9200", *addr)
+	}
+
+	// and reapplying doesn't repeat the repeated flag's values
+	if err := cfg.Apply(fs, cmdline); err != nil {
+		t.Fatal(err)
+	}
+	Reset(fs, cmdline)
+	if err := cfg.Apply(fs, cmdline); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(*probes, RepeatedFlag{"a,db/ddoc/view"}) {
+		t.Errorf("view-probe = %q, want [a,db/ddoc/view]", *probes)
+	}
+}
+
+func TestApplyModule(t *testing.T) {
+	fs, addr, nodeStats, _, _ := testFlags()
+	cfg, err := Load(writeConfig(t, "settings:\n  listen-address: :9100\nmodules:\n  nodes:\n    node-stats: true\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.Apply(fs, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.ApplyModule(fs, nil, "nodes"); err != nil {
+		t.Fatal(err)
+	}
+	if *addr != ":9100" || !*nodeStats {
+		t.Errorf("got listen-address %q and node-stats %v, want :9100 and true", *addr, *nodeStats)
+	}
+	if err := cfg.ApplyModule(fs, nil, "missing"); err == nil {
+		t.Error("expected an error for an unknown module")
+	}
+}
+
+func TestLoadSecretFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apikey")
+	if err := os.WriteFile(path, []byte("secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_APIKEY_FILE", path)
+	// so that t restores them afterwards
+	t.Setenv("TEST_APIKEY", "")
+	os.Unsetenv("TEST_APIKEY")
+
+	if err := LoadSecretFiles("TEST"); err != nil {
+		t.Fatal(err)
+	}
+	if got := os.Getenv("TEST_APIKEY"); got != "secret" {
+		t.Errorf("TEST_APIKEY = %q, want secret", got)
+	}
+	if _, ok := os.LookupEnv("TEST_APIKEY_FILE"); ok {
+		t.Error("TEST_APIKEY_FILE is still set")
+	}
+
+	// both set is ambiguous
+	t.Setenv("TEST_APIKEY_FILE", path)
+	if err := LoadSecretFiles("TEST"); err == nil {
+		t.Error("expected an error with both TEST_APIKEY and TEST_APIKEY_FILE set")
+	}
+}
@@ -1,42 +1,112 @@
 package monitors
 
 import (
-	"log"
+	"time"
 
+	"cloudant.com/cloudant_exporter/internal/registry"
 	"cloudant.com/cloudant_exporter/internal/utils"
 	"github.com/IBM/cloudant-go-sdk/cloudantv1"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 type ActiveTasksMonitor struct {
 	Cldt *cloudantv1.CloudantV1
+
+	// nodes is every node we've seen running a task, so we can
+	// report zero tasks for nodes which are no longer busy.
+	nodes map[string]bool
+}
+
+// taskTypes are the active task types which we count.
+var taskTypes = []string{"replication", "indexer", "database_compaction", "view_compaction", "search_indexer"}
+
+// taskKey identifies the tasks of one type on one node.
+type taskKey struct {
+	taskType string
+	node     string
 }
 
 var (
-	indexerChangesTotalGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	indexerChangesTotalGauge = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "cloudant_indexing_changes_total_documents",
 		Help: "The total number of changes to index",
 	},
 		[]string{"node", "pid", "database", "design_document"},
 	)
-	indexerChangesDoneCounter = utils.AutoNewSettableCounterVec(prometheus.Opts{
+	indexerChangesDoneCounter = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
 		Name: "cloudant_indexing_changes_done_total",
 		Help: "The total number of revisions processed by this indexer",
 	},
 		[]string{"node", "pid", "database", "design_document"},
+	))
+	indexerProgressGauge = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_indexing_progress_percent",
+		Help: "How far through its changes this indexer is, as a percentage",
+	},
+		[]string{"node", "pid", "database", "design_document"},
+	)
+	indexerChangesRemainingGauge = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_indexing_changes_remaining",
+		Help: "The number of changes this indexer has still to process",
+	},
+		[]string{"node", "pid", "database", "design_document"},
 	)
-	compactionChangesTotalGauge = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	searchIndexerChangesTotalGauge = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_search_indexing_changes_total_documents",
+		Help: "The total number of changes to index for this search index",
+	},
+		[]string{"node", "pid", "database", "design_document", "index"},
+	)
+	searchIndexerChangesDoneCounter = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
+		Name: "cloudant_search_indexing_changes_done_total",
+		Help: "The total number of revisions processed by this search indexer",
+	},
+		[]string{"node", "pid", "database", "design_document", "index"},
+	))
+	compactionChangesTotalGauge = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "cloudant_compaction_changes_total_documents",
 		Help: "The number of documents to compact",
 	},
 		[]string{"node", "pid", "database"},
 	)
-	compactionChangesDoneCounter = utils.AutoNewSettableCounterVec(prometheus.Opts{
+	compactionChangesDoneCounter = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
 		Name: "cloudant_compaction_changes_done_total",
 		Help: "The total number of documents compacted by this compaction",
 	},
 		[]string{"node", "pid", "database"},
+	))
+	compactionProgressGauge = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_compaction_progress_percent",
+		Help: "How far through its work this compaction is, as a percentage",
+	},
+		[]string{"node", "pid", "database", "type"},
+	)
+	activeTasksGauge = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_active_tasks",
+		Help: "The number of active tasks, by type",
+	},
+		[]string{"node", "type"},
+	)
+	activeTaskOldestGauge = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_active_task_oldest_started_seconds",
+		Help: "How long ago the longest-running active task of each type started, in seconds",
+	},
+		[]string{"node", "type"},
+	)
+	// This shows the spread of progress across the tasks running now,
+	// rather than accumulating over time.
+	activeTaskProgressHistogram = registry.Register(utils.NewSnapshotHistogramVec(prometheus.HistogramOpts{
+		Name:    "cloudant_active_task_progress_percent",
+		Help:    "The progress of currently active tasks, as a percentage, by type",
+		Buckets: prometheus.LinearBuckets(10, 10, 10),
+	},
+		[]string{"type"},
+	))
+	compactionRunningGauge = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_compaction_running",
+		Help: "The number of compactions currently running, by type",
+	},
+		[]string{"node", "type"},
 	)
 )
 
@@ -53,20 +123,102 @@ func (rc *ActiveTasksMonitor) Retrieve() error {
 		return err
 	}
 
+	if rc.nodes == nil {
+		rc.nodes = map[string]bool{}
+	}
+	typeCounts := map[taskKey]uint{}
+	// earliest started_on per type and node, as unix seconds
+	oldestStarted := map[taskKey]int64{}
+	compactionCounts := map[taskKey]uint{}
+	// the node and pid of every task running now
+	running := map[string]bool{}
+
+	progress := map[string][]float64{}
 	for _, d := range activeTaskResult {
+		rc.nodes[*d.Node] = true
+		running[utils.LabelsKey(*d.Node, *d.Pid)] = true
+		if *d.Type != "replication" {
+			progress[*d.Type] = append(progress[*d.Type], taskProgress(d))
+		}
+		k := taskKey{taskType: *d.Type, node: *d.Node}
+		typeCounts[k]++
+		if oldest, ok := oldestStarted[k]; !ok || *d.StartedOn < oldest {
+			oldestStarted[k] = *d.StartedOn
+		}
 		switch *d.Type {
 		case "indexer":
-			log.Printf("[ActiveTasksMonitor] indexing ddoc %q db %q: changes %d", *d.DesignDocument, *d.Database, *d.TotalChanges)
+			logger(rc).Debug("Indexing", "database", *d.Database, "design_document", *d.DesignDocument, "changes", *d.TotalChanges)
 			indexerChangesTotalGauge.WithLabelValues(*d.Node, *d.Pid, *d.Database, *d.DesignDocument).Set(float64(*d.TotalChanges))
 			indexerChangesDoneCounter.WithLabelValues(*d.Node, *d.Pid, *d.Database, *d.DesignDocument).Set(float64(*d.ChangesDone))
+			indexerProgressGauge.WithLabelValues(*d.Node, *d.Pid, *d.Database, *d.DesignDocument).Set(taskProgress(d))
+			indexerChangesRemainingGauge.WithLabelValues(*d.Node, *d.Pid, *d.Database, *d.DesignDocument).Set(float64(*d.TotalChanges - *d.ChangesDone))
+		case "search_indexer":
+			logger(rc).Debug("Search indexing", "database", *d.Database, "design_document", *d.DesignDocument, "index", *d.Index, "changes", *d.TotalChanges)
+			searchIndexerChangesTotalGauge.WithLabelValues(*d.Node, *d.Pid, *d.Database, *d.DesignDocument, *d.Index).Set(float64(*d.TotalChanges))
+			searchIndexerChangesDoneCounter.WithLabelValues(*d.Node, *d.Pid, *d.Database, *d.DesignDocument, *d.Index).Set(float64(*d.ChangesDone))
 		case "database_compaction":
-			log.Printf("[ActiveTasksMonitor] compaction db %q total change %d done %d", *d.Database, *d.TotalChanges, *d.ChangesDone)
+			logger(rc).Debug("Compaction", "database", *d.Database, "changes", *d.TotalChanges, "changes_done", *d.ChangesDone)
 			compactionChangesTotalGauge.WithLabelValues(*d.Node, *d.Pid, *d.Database).Set(float64(*d.TotalChanges))
 			compactionChangesDoneCounter.WithLabelValues(*d.Node, *d.Pid, *d.Database).Set(float64(*d.ChangesDone))
+			compactionProgressGauge.WithLabelValues(*d.Node, *d.Pid, *d.Database, "database").Set(taskProgress(d))
+			compactionCounts[taskKey{taskType: "database", node: *d.Node}]++
+		case "view_compaction":
+			logger(rc).Debug("View compaction", "database", *d.Database, "progress_percent", taskProgress(d))
+			compactionProgressGauge.WithLabelValues(*d.Node, *d.Pid, *d.Database, "view").Set(taskProgress(d))
+			compactionCounts[taskKey{taskType: "view", node: *d.Node}]++
 		default:
 			// no prometheus output for replication, as that's handled by the ReplicationMonitor
 		}
 	}
 
+	activeTaskProgressHistogram.Set(progress)
+
+	// tasks have a new pid each time they start, so remove the series
+	// of those which have finished
+	keep := utils.KeepLabels([]string{"node", "pid"}, running)
+	for _, vec := range []*prometheus.MetricVec{
+		indexerChangesTotalGauge.MetricVec,
+		indexerChangesDoneCounter.MetricVec,
+		indexerProgressGauge.MetricVec,
+		indexerChangesRemainingGauge.MetricVec,
+		searchIndexerChangesTotalGauge.MetricVec,
+		searchIndexerChangesDoneCounter.MetricVec,
+		compactionChangesTotalGauge.MetricVec,
+		compactionChangesDoneCounter.MetricVec,
+		compactionProgressGauge.MetricVec,
+	} {
+		utils.DeleteStale(vec, keep)
+	}
+
+	now := time.Now().Unix()
+	for node := range rc.nodes {
+		for _, t := range taskTypes {
+			k := taskKey{taskType: t, node: node}
+			activeTasksGauge.WithLabelValues(node, t).Set(float64(typeCounts[k]))
+			if started, ok := oldestStarted[k]; ok {
+				activeTaskOldestGauge.WithLabelValues(node, t).Set(float64(now - started))
+			} else {
+				activeTaskOldestGauge.WithLabelValues(node, t).Set(0)
+			}
+		}
+		for _, t := range []string{"database", "view"} {
+			k := taskKey{taskType: t, node: node}
+			compactionRunningGauge.WithLabelValues(node, t).Set(float64(compactionCounts[k]))
+		}
+	}
+
 	return nil
 }
+
+// taskProgress returns the percentage progress of a task, preferring
+// the progress reported by the server and otherwise working it out
+// from the changes done so far.
+func taskProgress(d cloudantv1.ActiveTask) float64 {
+	if d.Progress != nil {
+		return float64(*d.Progress)
+	}
+	if d.TotalChanges == nil || d.ChangesDone == nil || *d.TotalChanges == 0 {
+		return 0
+	}
+	return 100 * float64(*d.ChangesDone) / float64(*d.TotalChanges)
+}
@@ -0,0 +1,110 @@
+package monitors
+
+import (
+	"time"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// backupsBatchSize is the number of backup records
+// fetched per _find request.
+const backupsBatchSize = 1000
+
+// BackupsMonitor reads records of backups from Database, written by
+// backup tooling such as couchbackup, one document per backup:
+//
+//	{"database": "orders", "timestamp": "2023-05-01T02:00:00Z", "size": 1234, "success": true}
+//
+// The timestamp may also be given in unix seconds.
+type BackupsMonitor struct {
+	Cldt     *cloudantv1.CloudantV1
+	Database string
+}
+
+var (
+	backupLastSuccessAge = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_backup_last_success_age_seconds",
+		Help: "Seconds since the database's latest successful backup",
+	},
+		[]string{"database"},
+	)
+	backupSizeBytes = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_backup_size_bytes",
+		Help: "The size of the database's latest successful backup, in bytes",
+	},
+		[]string{"database"},
+	)
+)
+
+// backupRecord is the latest successful backup of a database.
+type backupRecord struct {
+	at   time.Time
+	size float64
+}
+
+func (bm *BackupsMonitor) Name() string {
+	return "BackupsMonitor"
+}
+
+func (bm *BackupsMonitor) Retrieve() error {
+	postFindOptions := bm.Cldt.NewPostFindOptions(bm.Database, map[string]interface{}{
+		"success": true,
+	})
+	postFindOptions.SetFields([]string{"database", "timestamp", "size"})
+	postFindOptions.SetLimit(backupsBatchSize)
+
+	latest := map[string]backupRecord{}
+	// repeat until we get a smaller batch than we asked for
+	for {
+		findResult, _, err := bm.Cldt.PostFind(postFindOptions)
+		if err != nil {
+			return err
+		}
+		for _, d := range findResult.Docs {
+			db, ok := d.GetProperty("database").(string)
+			if !ok {
+				continue
+			}
+			at, ok := backupTime(d.GetProperty("timestamp"))
+			if !ok {
+				continue
+			}
+			if prev, ok := latest[db]; ok && !at.After(prev.at) {
+				continue
+			}
+			size, _ := d.GetProperty("size").(float64)
+			latest[db] = backupRecord{at: at, size: size}
+		}
+		if len(findResult.Docs) < backupsBatchSize {
+			break
+		}
+		postFindOptions.SetBookmark(*findResult.Bookmark)
+	}
+
+	logger(bm).Info("Found backups", "databases", len(latest))
+	for db, b := range latest {
+		backupLastSuccessAge.WithLabelValues(db).Set(time.Since(b.at).Seconds())
+		backupSizeBytes.WithLabelValues(db).Set(b.size)
+	}
+
+	return nil
+}
+
+// backupTime parses a backup timestamp, given either as an
+// RFC 3339 string or a number of unix seconds.
+func backupTime(v interface{}) (time.Time, bool) {
+	switch ts := v.(type) {
+	case float64:
+		return time.Unix(int64(ts), 0), true
+	case string:
+		t, err := time.Parse(time.RFC3339, ts)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t, true
+	default:
+		return time.Time{}, false
+	}
+}
@@ -0,0 +1,112 @@
+package monitors
+
+import (
+	"net/http"
+	"time"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// canaryDocID is the ID of the document the CanaryMonitor writes.
+const canaryDocID = "cloudant_exporter_canary"
+
+// CanaryMonitor writes and then reads back a document in Database,
+// timing each. Failures are reported in metrics rather than
+// causing the monitor to exit, as they're what we're looking for.
+type CanaryMonitor struct {
+	Cldt     *cloudantv1.CloudantV1
+	Database string
+
+	// rev is the canary document's latest revision
+	rev string
+}
+
+var (
+	canaryWriteDuration = registry.Auto.NewHistogram(prometheus.HistogramOpts{
+		Name: "cloudant_canary_write_duration_seconds",
+		Help: "Time taken to write the canary document",
+	})
+	canaryReadDuration = registry.Auto.NewHistogram(prometheus.HistogramOpts{
+		Name: "cloudant_canary_read_duration_seconds",
+		Help: "Time taken to read the canary document",
+	})
+	canaryWriteSuccess = registry.Auto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudant_canary_write_success",
+		Help: "Whether the last write of the canary document succeeded (1) or not (0)",
+	})
+	canaryReadSuccess = registry.Auto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudant_canary_read_success",
+		Help: "Whether the last read of the canary document succeeded (1) or not (0)",
+	})
+)
+
+func (cm *CanaryMonitor) Name() string {
+	return "CanaryMonitor"
+}
+
+func (cm *CanaryMonitor) Retrieve() error {
+	err := cm.write()
+	if err != nil {
+		logger(cm).Warn("Canary write failed", "err", err)
+		// we may have a stale revision, so fetch it afresh next time
+		cm.rev = ""
+	}
+	canaryWriteSuccess.Set(boolToFloat(err == nil))
+
+	err = cm.read()
+	if err != nil {
+		logger(cm).Warn("Canary read failed", "err", err)
+	}
+	canaryReadSuccess.Set(boolToFloat(err == nil))
+
+	return nil
+}
+
+// write updates the canary document with the current time.
+func (cm *CanaryMonitor) write() error {
+	if cm.rev == "" {
+		// find the latest revision, if the document exists
+		getDocumentOptions := cm.Cldt.NewGetDocumentOptions(cm.Database, canaryDocID)
+		doc, resp, err := cm.Cldt.GetDocument(getDocumentOptions)
+		if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+			return err
+		}
+		if doc != nil && doc.Rev != nil {
+			cm.rev = *doc.Rev
+		}
+	}
+
+	doc := &cloudantv1.Document{}
+	if cm.rev != "" {
+		doc.Rev = &cm.rev
+	}
+	doc.SetProperty("written", time.Now().Unix())
+	putDocumentOptions := cm.Cldt.NewPutDocumentOptions(cm.Database, canaryDocID)
+	putDocumentOptions.SetDocument(doc)
+
+	start := time.Now()
+	result, _, err := cm.Cldt.PutDocument(putDocumentOptions)
+	if err != nil {
+		return err
+	}
+	canaryWriteDuration.Observe(time.Since(start).Seconds())
+	cm.rev = *result.Rev
+
+	return nil
+}
+
+// read fetches the canary document.
+func (cm *CanaryMonitor) read() error {
+	getDocumentOptions := cm.Cldt.NewGetDocumentOptions(cm.Database, canaryDocID)
+
+	start := time.Now()
+	_, _, err := cm.Cldt.GetDocument(getDocumentOptions)
+	if err != nil {
+		return err
+	}
+	canaryReadDuration.Observe(time.Since(start).Seconds())
+
+	return nil
+}
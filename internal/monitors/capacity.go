@@ -0,0 +1,79 @@
+package monitors
+
+import (
+	"strconv"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"cloudant.com/cloudant_exporter/internal/utils"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type CapacityMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+}
+
+var (
+	capacityBlocks = registry.Auto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cloudant_capacity_throughput_blocks",
+			Help: "Provisioned throughput capacity blocks, current and target",
+		},
+		[]string{"setting"},
+	)
+	capacityThroughput = registry.Auto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cloudant_capacity_throughput_req_per_second",
+			Help: "Provisioned requests per second per class, current and target",
+		},
+		[]string{"class", "setting"},
+	)
+	instanceInfo = registry.Auto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cloudant_instance_info",
+			Help: "The instance's current provisioned capacity; always 1",
+		},
+		[]string{"blocks", "lookup", "write", "query"},
+	)
+)
+
+func (cm *CapacityMonitor) Name() string {
+	return "CapacityMonitor"
+}
+
+func (cm *CapacityMonitor) Retrieve() error {
+	getCapacityThroughputInformationOptions := cm.Cldt.NewGetCapacityThroughputInformationOptions()
+	capacityResult, _, err := cm.Cldt.GetCapacityThroughputInformation(getCapacityThroughputInformationOptions)
+	if err != nil {
+		return err
+	}
+
+	current := capacityResult.Current.Throughput
+	logger(cm).Info("Read capacity", "blocks", *current.Blocks)
+	setCapacity("current", current)
+
+	info := []string{
+		strconv.FormatInt(*current.Blocks, 10),
+		strconv.FormatInt(*current.Read, 10),
+		strconv.FormatInt(*current.Write, 10),
+		strconv.FormatInt(*current.Query, 10),
+	}
+	instanceInfo.WithLabelValues(info...).Set(1)
+	// the previous capacity, if it has changed
+	infoLabels := []string{"blocks", "lookup", "write", "query"}
+	utils.DeleteStale(instanceInfo.MetricVec, utils.KeepLabels(infoLabels, map[string]bool{utils.LabelsKey(info...): true}))
+	if capacityResult.Target != nil {
+		setCapacity("target", capacityResult.Target.Throughput)
+	}
+
+	return nil
+}
+
+// setCapacity publishes ti under the given setting label. We use the
+// same class names as the ThroughputMonitor so the two can be compared.
+func setCapacity(setting string, ti *cloudantv1.ThroughputInformation) {
+	capacityBlocks.WithLabelValues(setting).Set(float64(*ti.Blocks))
+	capacityThroughput.WithLabelValues("lookup", setting).Set(float64(*ti.Read))
+	capacityThroughput.WithLabelValues("write", setting).Set(float64(*ti.Write))
+	capacityThroughput.WithLabelValues("query", setting).Set(float64(*ti.Query))
+}
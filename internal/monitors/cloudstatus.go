@@ -0,0 +1,75 @@
+package monitors
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DefaultCloudStatusURL is the RSS feed of IBM Cloud status notifications.
+const DefaultCloudStatusURL = "https://cloud.ibm.com/status/api/notifications/feed.rss"
+
+// CloudStatusMonitor reads the IBM Cloud status feed, looking for
+// unresolved notifications about Cloudant in Region. The feed isn't
+// structured, so this matches on the notifications' text.
+type CloudStatusMonitor struct {
+	Client *http.Client
+	URL    string
+	Region string
+}
+
+var (
+	cloudServiceStatus = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_cloud_service_status",
+		Help: "Whether IBM Cloud reports no current incidents for Cloudant in the region (1) or some (0)",
+	},
+		[]string{"region"},
+	)
+)
+
+// statusFeed is the subset of the RSS status feed that we use.
+type statusFeed struct {
+	Items []struct {
+		Title       string `xml:"title"`
+		Description string `xml:"description"`
+	} `xml:"channel>item"`
+}
+
+func (cs *CloudStatusMonitor) Name() string {
+	return "CloudStatusMonitor"
+}
+
+func (cs *CloudStatusMonitor) Retrieve() error {
+	resp, err := cs.Client.Get(cs.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status feed returned %s", resp.Status)
+	}
+
+	feed := &statusFeed{}
+	if err := xml.NewDecoder(resp.Body).Decode(feed); err != nil {
+		return err
+	}
+
+	incidents := 0
+	for _, item := range feed.Items {
+		text := strings.ToLower(item.Title + " " + item.Description)
+		if strings.Contains(text, "cloudant") &&
+			strings.Contains(text, strings.ToLower(cs.Region)) &&
+			!strings.Contains(text, "resolved") {
+			incidents++
+		}
+	}
+
+	logger(cs).Info("Read IBM Cloud status", "region", cs.Region, "notifications", incidents)
+	cloudServiceStatus.WithLabelValues(cs.Region).Set(boolToFloat(incidents == 0))
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package monitors
+
+import (
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// conflictsBatchSize is the number of conflicted documents
+// fetched per _find request while counting them.
+const conflictsBatchSize = 1000
+
+// ConflictsMonitor counts the conflicted documents in each of
+// Databases. Without an index on _conflicts this scans the whole
+// database, so should only be used for databases which need it.
+type ConflictsMonitor struct {
+	Cldt      *cloudantv1.CloudantV1
+	Databases []string
+}
+
+var (
+	databaseConflictedDocs = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_conflicted_docs",
+		Help: "The number of documents in the database with conflicts",
+	},
+		[]string{"database"},
+	)
+)
+
+func (cm *ConflictsMonitor) Name() string {
+	return "ConflictsMonitor"
+}
+
+func (cm *ConflictsMonitor) Retrieve() error {
+	for _, db := range cm.Databases {
+		n, err := conflictedDocs(cm.Cldt, db)
+		if err != nil {
+			return err
+		}
+		logger(cm).Debug("Counted conflicted documents", "database", db, "conflicted_docs", n)
+		databaseConflictedDocs.WithLabelValues(db).Set(float64(n))
+	}
+
+	return nil
+}
+
+// conflictedDocs counts the documents in db which have a _conflicts
+// field, paging through them with the _find bookmark.
+func conflictedDocs(cldt *cloudantv1.CloudantV1, db string) (int, error) {
+	postFindOptions := cldt.NewPostFindOptions(db, map[string]interface{}{
+		"_conflicts": map[string]interface{}{"$exists": true},
+	})
+	postFindOptions.SetConflicts(true)
+	postFindOptions.SetFields([]string{"_id"})
+	postFindOptions.SetLimit(conflictsBatchSize)
+
+	count := 0
+	// repeat until we get a smaller batch than we asked for
+	for {
+		findResult, _, err := cldt.PostFind(postFindOptions)
+		if err != nil {
+			return 0, err
+		}
+		count += len(findResult.Docs)
+		if len(findResult.Docs) < conflictsBatchSize {
+			break
+		}
+		postFindOptions.SetBookmark(*findResult.Bookmark)
+	}
+
+	return count, nil
+}
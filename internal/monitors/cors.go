@@ -0,0 +1,45 @@
+package monitors
+
+import (
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type CorsMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+}
+
+var (
+	corsEnabled = registry.Auto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudant_cors_enabled",
+		Help: "Whether CORS is enabled (1) or not (0)",
+	})
+	corsAllowCredentials = registry.Auto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudant_cors_allow_credentials",
+		Help: "Whether CORS requests may include credentials (1) or not (0)",
+	})
+	corsOrigins = registry.Auto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudant_cors_origins",
+		Help: "The number of origins allowed by the CORS configuration",
+	})
+)
+
+func (cm *CorsMonitor) Name() string {
+	return "CorsMonitor"
+}
+
+func (cm *CorsMonitor) Retrieve() error {
+	getCorsInformationOptions := cm.Cldt.NewGetCorsInformationOptions()
+	corsResult, _, err := cm.Cldt.GetCorsInformation(getCorsInformationOptions)
+	if err != nil {
+		return err
+	}
+
+	logger(cm).Info("Read CORS configuration", "enabled", *corsResult.EnableCors, "origins", len(corsResult.Origins))
+	corsEnabled.Set(boolToFloat(*corsResult.EnableCors))
+	corsAllowCredentials.Set(boolToFloat(*corsResult.AllowCredentials))
+	corsOrigins.Set(float64(len(corsResult.Origins)))
+
+	return nil
+}
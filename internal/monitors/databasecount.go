@@ -0,0 +1,52 @@
+package monitors
+
+import (
+	"strings"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type DatabaseCountMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+}
+
+var (
+	accountDatabaseTotal = registry.Auto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudant_account_database_total",
+		Help: "The number of databases in the account",
+	})
+	accountPeruserDatabaseTotal = registry.Auto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudant_account_peruser_database_total",
+		Help: "The number of per-user (userdb-*) databases in the account",
+	})
+)
+
+// peruserPrefix is the prefix of the databases created
+// for each user by couch_peruser.
+const peruserPrefix = "userdb-"
+
+func (dc *DatabaseCountMonitor) Name() string {
+	return "DatabaseCountMonitor"
+}
+
+func (dc *DatabaseCountMonitor) Retrieve() error {
+	dbs, err := allDbs(dc.Cldt, nil)
+	if err != nil {
+		return err
+	}
+
+	peruser := 0
+	for _, db := range dbs {
+		if strings.HasPrefix(db, peruserPrefix) {
+			peruser++
+		}
+	}
+
+	logger(dc).Info("Counted databases", "databases", len(dbs), "peruser", peruser)
+	accountDatabaseTotal.Set(float64(len(dbs)))
+	accountPeruserDatabaseTotal.Set(float64(peruser))
+
+	return nil
+}
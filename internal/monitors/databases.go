@@ -0,0 +1,336 @@
+package monitors
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"cloudant.com/cloudant_exporter/internal/utils"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// allDbsBatchSize is the page size used when listing databases.
+const allDbsBatchSize = 1000
+
+// dbsInfoBatchSize is the maximum number of databases Cloudant
+// will accept in a single POST /_dbs_info request.
+const dbsInfoBatchSize = 100
+
+type DatabasesMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+
+	// Filter chooses the databases to publish.
+	Filter *DatabaseFilter
+
+	// TopN, if set, limits the per-database metrics to the
+	// N databases with the most active data.
+	TopN int
+
+	// docTotals is the live plus deleted document count for
+	// each database at the last poll, for counting documents created.
+	docTotals map[string]int64
+}
+
+var (
+	accountTotalDataSizeBytes = registry.Auto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudant_account_total_data_size_bytes",
+		Help: "The size of live data across all databases in the account, in bytes",
+	})
+	databaseDocCount = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_doc_count",
+		Help: "The number of documents in the database",
+	},
+		[]string{"database"},
+	)
+	databaseDeletedDocCount = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_deleted_doc_count",
+		Help: "The number of deleted documents in the database",
+	},
+		[]string{"database"},
+	)
+	databaseDeletedDocRatio = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_deleted_doc_ratio",
+		Help: "The proportion of the database's documents that are deleted (0-1)",
+	},
+		[]string{"database"},
+	)
+	databaseActiveSizeBytes = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_active_size_bytes",
+		Help: "The size of live data inside the database, in bytes",
+	},
+		[]string{"database"},
+	)
+	databaseFileSizeBytes = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_file_size_bytes",
+		Help: "The size of the database files on disk, in bytes",
+	},
+		[]string{"database"},
+	)
+	// The external size is the uncompressed size of the database's
+	// contents, including attachments.
+	databaseExternalSizeBytes = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_external_size_bytes",
+		Help: "The uncompressed size of the database's documents and attachments, in bytes",
+	},
+		[]string{"database"},
+	)
+	databasePartitioned = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_partitioned",
+		Help: "Whether the database is partitioned (1) or not (0)",
+	},
+		[]string{"database"},
+	)
+	databaseShardingInfo = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_sharding_info",
+		Help: "The database's shard count (q) and replica count (n); always 1",
+	},
+		[]string{"database", "q", "n"},
+	)
+	// The numeric prefix of a clustered update_seq is the sum of the
+	// sequences of the database's shards, so it increases by roughly
+	// one for every write to the database.
+	databaseUpdatesTotal = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
+		Name: "cloudant_database_updates_total",
+		Help: "The number of updates made to the database (approximately)",
+	},
+		[]string{"database"},
+	))
+	databasePurgeSeq = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_purge_seq",
+		Help: "The database's purge sequence, which increases as documents are purged",
+	},
+		[]string{"database"},
+	)
+	// Every new document adds one to doc_count+doc_del_count, while a
+	// deletion only moves a document from doc_count to doc_del_count, so
+	// the increase between polls counts the documents created. Updates
+	// and deletions aren't counted.
+	databaseDocumentWritesTotal = registry.Auto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloudant_database_document_writes_total",
+		Help: "The number of documents created in the database (approximately)",
+	},
+		[]string{"database"},
+	)
+	// Fragmentation is the proportion of the file on disk that is no
+	// longer live data, and which compaction could reclaim.
+	databaseFragmentationRatio = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_fragmentation_ratio",
+		Help: "The proportion of the database file that is not active data (0-1)",
+	},
+		[]string{"database"},
+	)
+)
+
+// dbsInfoResult is a row of the POST /_dbs_info response. We decode this
+// ourselves as the SDK's model doesn't include the purge_seq.
+type dbsInfoResult struct {
+	Key  string               `json:"key"`
+	Info *databaseInformation `json:"info"`
+}
+
+type databaseInformation struct {
+	cloudantv1.DatabaseInformation
+	PurgeSeq json.RawMessage `json:"purge_seq"`
+}
+
+func (dm *DatabasesMonitor) Name() string {
+	return "DatabasesMonitor"
+}
+
+func (dm *DatabasesMonitor) Retrieve() error {
+	dbs, err := allDbs(dm.Cldt, dm.Filter)
+	if err != nil {
+		return err
+	}
+
+	// fetch database info in batches
+	infos := []dbsInfoResult{}
+	for start := 0; start < len(dbs); start += dbsInfoBatchSize {
+		end := start + dbsInfoBatchSize
+		if end > len(dbs) {
+			end = len(dbs)
+		}
+
+		batch := []dbsInfoResult{}
+		err := postJSON(dm.Cldt, "PostDbsInfo", `/_dbs_info`, map[string][]string{"keys": dbs[start:end]}, &batch)
+		if err != nil {
+			return err
+		}
+
+		for _, d := range batch {
+			if d.Info == nil {
+				// the database may have been deleted since we listed it
+				continue
+			}
+			infos = append(infos, d)
+		}
+	}
+
+	var totalSize int64
+	for _, d := range infos {
+		totalSize += *d.Info.Sizes.Active
+	}
+	accountTotalDataSizeBytes.Set(float64(totalSize))
+
+	if dm.TopN > 0 {
+		// Only publish the largest databases. As the largest databases
+		// change over time, those no longer in the top N are removed below.
+		sort.Slice(infos, func(i, j int) bool {
+			return *infos[i].Info.Sizes.Active > *infos[j].Info.Sizes.Active
+		})
+		if len(infos) > dm.TopN {
+			infos = infos[:dm.TopN]
+		}
+	}
+
+	if dm.docTotals == nil {
+		dm.docTotals = map[string]int64{}
+	}
+	published := map[string]*databaseInformation{}
+	for _, d := range infos {
+		publishDatabase(d.Key, d.Info)
+		dm.countWrites(d.Key, *d.Info.DocCount+*d.Info.DocDelCount)
+		published[d.Key] = d.Info
+	}
+	// forget databases which have been deleted, filtered out or left the top N
+	for db := range dm.docTotals {
+		if published[db] == nil {
+			delete(dm.docTotals, db)
+			databaseDocumentWritesTotal.DeleteLabelValues(db)
+		}
+	}
+	deleteStaleDatabases(published)
+	logger(dm).Info("Retrieved database info", "databases", len(dbs), "published", len(infos))
+
+	return nil
+}
+
+// publishDatabase sets the per-database metrics for db from info.
+func publishDatabase(db string, info *databaseInformation) {
+	databaseDocCount.WithLabelValues(db).Set(float64(*info.DocCount))
+	databaseDeletedDocCount.WithLabelValues(db).Set(float64(*info.DocDelCount))
+	databaseDeletedDocRatio.WithLabelValues(db).Set(deletedRatio(*info.DocCount, *info.DocDelCount))
+	databaseActiveSizeBytes.WithLabelValues(db).Set(float64(*info.Sizes.Active))
+	databaseFileSizeBytes.WithLabelValues(db).Set(float64(*info.Sizes.File))
+	databaseFragmentationRatio.WithLabelValues(db).Set(fragmentation(info.Sizes))
+	if info.Sizes.External != nil {
+		databaseExternalSizeBytes.WithLabelValues(db).Set(float64(*info.Sizes.External))
+	}
+	if info.Cluster != nil {
+		q := strconv.FormatInt(*info.Cluster.Q, 10)
+		n := strconv.FormatInt(*info.Cluster.N, 10)
+		databaseShardingInfo.WithLabelValues(db, q, n).Set(1)
+	}
+	if seq, ok := seqNumber(*info.UpdateSeq); ok {
+		databaseUpdatesTotal.WithLabelValues(db).Set(float64(seq))
+	}
+	if purgeSeq, ok := rawSeqNumber(info.PurgeSeq); ok {
+		databasePurgeSeq.WithLabelValues(db).Set(float64(purgeSeq))
+	}
+	partitioned := info.Props != nil && info.Props.Partitioned != nil && *info.Props.Partitioned
+	databasePartitioned.WithLabelValues(db).Set(boolToFloat(partitioned))
+}
+
+// countWrites adds the increase in db's total document count since the
+// last poll to its writes counter. If the total went down, the database
+// must have been recreated or purged, so we start counting again from it.
+func (dm *DatabasesMonitor) countWrites(db string, total int64) {
+	prev, ok := dm.docTotals[db]
+	dm.docTotals[db] = total
+	if !ok {
+		databaseDocumentWritesTotal.WithLabelValues(db).Add(0)
+		return
+	}
+	if total > prev {
+		databaseDocumentWritesTotal.WithLabelValues(db).Add(float64(total - prev))
+	}
+}
+
+// deleteStaleDatabases removes the per-database series of the databases
+// not published, and the sharding info of those since resharded.
+func deleteStaleDatabases(published map[string]*databaseInformation) {
+	keep := func(labels prometheus.Labels) bool {
+		return published[labels["database"]] != nil
+	}
+	for _, vec := range []*prometheus.MetricVec{
+		databaseDocCount.MetricVec,
+		databaseDeletedDocCount.MetricVec,
+		databaseDeletedDocRatio.MetricVec,
+		databaseActiveSizeBytes.MetricVec,
+		databaseFileSizeBytes.MetricVec,
+		databaseExternalSizeBytes.MetricVec,
+		databaseFragmentationRatio.MetricVec,
+		databaseUpdatesTotal.MetricVec,
+		databasePartitioned.MetricVec,
+		databasePurgeSeq.MetricVec,
+	} {
+		utils.DeleteStale(vec, keep)
+	}
+	utils.DeleteStale(databaseShardingInfo.MetricVec, func(labels prometheus.Labels) bool {
+		info := published[labels["database"]]
+		return info != nil && info.Cluster != nil &&
+			labels["q"] == strconv.FormatInt(*info.Cluster.Q, 10) &&
+			labels["n"] == strconv.FormatInt(*info.Cluster.N, 10)
+	})
+}
+
+// allDbs lists the databases in the account which pass filter, paging
+// through GET /_all_dbs allDbsBatchSize databases at a time.
+func allDbs(cldt *cloudantv1.CloudantV1, filter *DatabaseFilter) ([]string, error) {
+	dbs := []string{}
+	listed := 0
+	getAllDbsOptions := cldt.NewGetAllDbsOptions()
+	getAllDbsOptions.SetLimit(allDbsBatchSize)
+
+	// repeat until we get a smaller batch than we asked for
+	for {
+		getAllDbsOptions.SetSkip(int64(listed))
+		allDbsResult, _, err := cldt.GetAllDbs(getAllDbsOptions)
+		if err != nil {
+			return nil, err
+		}
+		listed += len(allDbsResult)
+		for _, db := range allDbsResult {
+			if filter.Match(db) {
+				dbs = append(dbs, db)
+			}
+		}
+		if len(allDbsResult) < allDbsBatchSize {
+			break
+		}
+	}
+
+	return dbs, nil
+}
+
+// fragmentation returns the fraction of the on-disk file
+// that is not taken up by active data.
+func fragmentation(sizes *cloudantv1.ContentInformationSizes) float64 {
+	if *sizes.File <= 0 || *sizes.Active >= *sizes.File {
+		return 0
+	}
+	return float64(*sizes.File-*sizes.Active) / float64(*sizes.File)
+}
+
+// deletedRatio returns the fraction of all documents,
+// live and deleted, which are deleted.
+func deletedRatio(docs, deleted int64) float64 {
+	if docs+deleted <= 0 {
+		return 0
+	}
+	return float64(deleted) / float64(docs+deleted)
+}
+
+// seqNumber returns the numeric prefix of a sequence such as
+// "1234-g1AAAA...", if it has one.
+func seqNumber(seq string) (int64, bool) {
+	prefix, _, _ := strings.Cut(seq, "-")
+	n, err := strconv.ParseInt(prefix, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
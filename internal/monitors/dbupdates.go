@@ -0,0 +1,56 @@
+package monitors
+
+import (
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// DbUpdatesMonitor follows the /_db_updates feed, counting
+// database events since the exporter started.
+type DbUpdatesMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+
+	// since is the sequence to read the feed from next time
+	since string
+}
+
+var (
+	dbUpdatesEventsTotal = registry.Auto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloudant_db_updates_events_total",
+		Help: "The number of database events seen in the _db_updates feed, by type",
+	},
+		[]string{"type"},
+	)
+)
+
+func (du *DbUpdatesMonitor) Name() string {
+	return "DbUpdatesMonitor"
+}
+
+func (du *DbUpdatesMonitor) Retrieve() error {
+	getDbUpdatesOptions := du.Cldt.NewGetDbUpdatesOptions()
+	getDbUpdatesOptions.SetFeed(cloudantv1.GetDbUpdatesOptionsFeedNormalConst)
+	if du.since == "" {
+		// start from the current end of the feed
+		getDbUpdatesOptions.SetSince("now")
+		for _, t := range []string{"created", "deleted", "updated"} {
+			dbUpdatesEventsTotal.WithLabelValues(t)
+		}
+	} else {
+		getDbUpdatesOptions.SetSince(du.since)
+	}
+
+	dbUpdatesResult, _, err := du.Cldt.GetDbUpdates(getDbUpdatesOptions)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range dbUpdatesResult.Results {
+		dbUpdatesEventsTotal.WithLabelValues(*e.Type).Inc()
+	}
+	logger(du).Info("Read database events", "events", len(dbUpdatesResult.Results))
+	du.since = *dbUpdatesResult.LastSeq
+
+	return nil
+}
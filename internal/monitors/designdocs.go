@@ -0,0 +1,75 @@
+package monitors
+
+import (
+	"errors"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"cloudant.com/cloudant_exporter/internal/utils"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type DesignDocsMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+
+	// Filter chooses the databases to check.
+	Filter *DatabaseFilter
+}
+
+var (
+	databaseDesignDocTotal = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_design_doc_total",
+		Help: "The number of design documents in the database",
+	},
+		[]string{"database"},
+	)
+)
+
+func (dd *DesignDocsMonitor) Name() string {
+	return "DesignDocsMonitor"
+}
+
+func (dd *DesignDocsMonitor) Retrieve() error {
+	dbs, err := allDbs(dd.Cldt, dd.Filter)
+	if err != nil {
+		return err
+	}
+
+	counted := map[string]bool{}
+	for _, db := range dbs {
+		ddocs, err := designDocIDs(dd.Cldt, db)
+		if errors.Is(err, errNotFound) {
+			logger(dd).Debug("Database deleted since listing it", "database", db)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		databaseDesignDocTotal.WithLabelValues(db).Set(float64(len(ddocs)))
+		counted[db] = true
+	}
+	utils.DeleteStale(databaseDesignDocTotal.MetricVec, utils.KeepLabel("database", counted))
+	logger(dd).Info("Counted design documents", "databases", len(dbs))
+
+	return nil
+}
+
+// designDocIDs returns the IDs of all the design documents in db,
+// using the _design/ key range of _all_docs. The error is errNotFound
+// if db doesn't exist.
+func designDocIDs(cldt *cloudantv1.CloudantV1, db string) ([]string, error) {
+	postAllDocsOptions := cldt.NewPostAllDocsOptions(db)
+	postAllDocsOptions.SetStartKey("_design/")
+	postAllDocsOptions.SetEndKey("_design0")
+
+	allDocsResult, resp, err := cldt.PostAllDocs(postAllDocsOptions)
+	if err != nil {
+		return nil, wrapNotFound(resp, err)
+	}
+
+	ids := make([]string, 0, len(allDocsResult.Rows))
+	for _, r := range allDocsResult.Rows {
+		ids = append(ids, *r.ID)
+	}
+	return ids, nil
+}
@@ -0,0 +1,81 @@
+package monitors
+
+import (
+	"fmt"
+	"strings"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ReplicationPair is a replication between two databases in the account.
+type ReplicationPair struct {
+	DocID  string
+	Source string
+	Target string
+}
+
+// ParseReplicationPair parses a pair given as "docid:source:target".
+// Database names can't contain colons, but document IDs can, so the
+// databases are taken from the end.
+func ParseReplicationPair(s string) (ReplicationPair, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 3 {
+		return ReplicationPair{}, fmt.Errorf("invalid replication pair %q, expected docid:source:target", s)
+	}
+	n := len(parts)
+	p := ReplicationPair{DocID: strings.Join(parts[:n-2], ":"), Source: parts[n-2], Target: parts[n-1]}
+	if p.DocID == "" || p.Source == "" || p.Target == "" {
+		return ReplicationPair{}, fmt.Errorf("invalid replication pair %q, expected docid:source:target", s)
+	}
+	return p, nil
+}
+
+// DocCountDriftMonitor compares the document counts of the source
+// and target of each of Pairs. A replication can be running without
+// errors while the databases still differ, eg if it's filtered wrongly.
+type DocCountDriftMonitor struct {
+	Cldt  *cloudantv1.CloudantV1
+	Pairs []ReplicationPair
+}
+
+var (
+	replicationDocCountDrift = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_replication_doc_count_drift",
+		Help: "The source's document count less the target's",
+	},
+		[]string{"docid"},
+	)
+)
+
+func (dd *DocCountDriftMonitor) Name() string {
+	return "DocCountDriftMonitor"
+}
+
+func (dd *DocCountDriftMonitor) Retrieve() error {
+	for _, p := range dd.Pairs {
+		sourceCount, err := dd.docCount(p.Source)
+		if err != nil {
+			return err
+		}
+		targetCount, err := dd.docCount(p.Target)
+		if err != nil {
+			return err
+		}
+
+		logger(dd).Debug("Compared document counts", "docid", p.DocID, "source", sourceCount, "target", targetCount)
+		replicationDocCountDrift.WithLabelValues(p.DocID).Set(float64(sourceCount - targetCount))
+	}
+
+	return nil
+}
+
+func (dd *DocCountDriftMonitor) docCount(db string) (int64, error) {
+	getDatabaseInformationOptions := dd.Cldt.NewGetDatabaseInformationOptions(db)
+	dbInfo, _, err := dd.Cldt.GetDatabaseInformation(getDatabaseInformationOptions)
+	if err != nil {
+		return 0, err
+	}
+	return *dbInfo.DocCount, nil
+}
@@ -0,0 +1,39 @@
+package monitors
+
+import "testing"
+
+func TestParseReplicationPair(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ReplicationPair
+		err  bool
+	}{
+		{in: "backup:orders:orders-backup", want: ReplicationPair{DocID: "backup", Source: "orders", Target: "orders-backup"}},
+		{
+			// document IDs can contain colons, database names can't
+			in:   "urn:backup:orders:orders-backup",
+			want: ReplicationPair{DocID: "urn:backup", Source: "orders", Target: "orders-backup"},
+		},
+		{in: "orders:orders-backup", err: true},
+		{in: ":orders:orders-backup", err: true},
+		{in: "backup::orders-backup", err: true},
+		{in: "backup:orders:", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseReplicationPair(tt.in)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
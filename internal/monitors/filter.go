@@ -0,0 +1,49 @@
+package monitors
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// DatabaseFilter chooses which databases the per-database monitors
+// look at. A nil DatabaseFilter includes every database.
+type DatabaseFilter struct {
+	// Include, if set, only includes databases matching it.
+	Include *regexp.Regexp
+	// Exclude, if set, excludes databases matching it, even if they
+	// match Include.
+	Exclude *regexp.Regexp
+}
+
+// ParseDatabaseFilter compiles the include and exclude regular
+// expressions, either of which may be empty. It returns nil if
+// both are empty.
+func ParseDatabaseFilter(include, exclude string) (*DatabaseFilter, error) {
+	if include == "" && exclude == "" {
+		return nil, nil
+	}
+	f := &DatabaseFilter{}
+	var err error
+	if include != "" {
+		if f.Include, err = regexp.Compile(include); err != nil {
+			return nil, fmt.Errorf("invalid include regexp %q: %w", include, err)
+		}
+	}
+	if exclude != "" {
+		if f.Exclude, err = regexp.Compile(exclude); err != nil {
+			return nil, fmt.Errorf("invalid exclude regexp %q: %w", exclude, err)
+		}
+	}
+	return f, nil
+}
+
+// Match reports whether db passes the filter.
+func (f *DatabaseFilter) Match(db string) bool {
+	if f == nil {
+		return true
+	}
+	if f.Include != nil && !f.Include.MatchString(db) {
+		return false
+	}
+	return f.Exclude == nil || !f.Exclude.MatchString(db)
+}
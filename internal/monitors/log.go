@@ -0,0 +1,13 @@
+package monitors
+
+import "log/slog"
+
+// logger returns the default logger, with the monitor's name on each line.
+func logger(m monitor) *slog.Logger {
+	return slog.Default().With("monitor", m.Name())
+}
+
+// monitor is the part of a monitor that logger needs.
+type monitor interface {
+	Name() string
+}
@@ -0,0 +1,81 @@
+package monitors
+
+import (
+	"errors"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"cloudant.com/cloudant_exporter/internal/utils"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type MangoIndexesMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+
+	// Filter chooses the databases to check.
+	Filter *DatabaseFilter
+}
+
+var (
+	databaseMangoIndexTotal = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_mango_index_total",
+		Help: "The number of Cloudant Query indexes in the database, by index type",
+	},
+		[]string{"database", "type"},
+	)
+	// Text indexes are backed by Lucene, so are much more expensive
+	// than JSON indexes, and worth watching on their own.
+	databaseTextIndexTotal = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_text_index_total",
+		Help: "The number of Cloudant Query text indexes in the database",
+	},
+		[]string{"database"},
+	)
+)
+
+func (mi *MangoIndexesMonitor) Name() string {
+	return "MangoIndexesMonitor"
+}
+
+func (mi *MangoIndexesMonitor) Retrieve() error {
+	dbs, err := allDbs(mi.Cldt, mi.Filter)
+	if err != nil {
+		return err
+	}
+
+	counted := map[string]bool{}
+	for _, db := range dbs {
+		getIndexesInformationOptions := mi.Cldt.NewGetIndexesInformationOptions(db)
+		indexesResult, resp, err := mi.Cldt.GetIndexesInformation(getIndexesInformationOptions)
+		err = wrapNotFound(resp, err)
+		if errors.Is(err, errNotFound) {
+			logger(mi).Debug("Database deleted since listing it", "database", db)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		typeCounts := map[string]uint{
+			"json": 0,
+			"text": 0,
+		}
+		for _, i := range indexesResult.Indexes {
+			// the "special" type is the built-in _all_docs index
+			if *i.Type == "special" {
+				continue
+			}
+			typeCounts[*i.Type]++
+		}
+		for key, val := range typeCounts {
+			databaseMangoIndexTotal.WithLabelValues(db, key).Set(float64(val))
+		}
+		databaseTextIndexTotal.WithLabelValues(db).Set(float64(typeCounts["text"]))
+		counted[db] = true
+	}
+	utils.DeleteStale(databaseMangoIndexTotal.MetricVec, utils.KeepLabel("database", counted))
+	utils.DeleteStale(databaseTextIndexTotal.MetricVec, utils.KeepLabel("database", counted))
+	logger(mi).Info("Counted indexes", "databases", len(dbs))
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package monitors
+
+import (
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type MembershipMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+}
+
+var (
+	clusterNodeKnown = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_cluster_node_known",
+		Help: "Whether the node is configured as part of the cluster (1) or not (0)",
+	},
+		[]string{"node"},
+	)
+	clusterNodeConnected = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_cluster_node_connected",
+		Help: "Whether the node is connected to the cluster (1) or not (0)",
+	},
+		[]string{"node"},
+	)
+)
+
+func (mm *MembershipMonitor) Name() string {
+	return "MembershipMonitor"
+}
+
+func (mm *MembershipMonitor) Retrieve() error {
+	getMembershipInformationOptions := mm.Cldt.NewGetMembershipInformationOptions()
+	membershipResult, _, err := mm.Cldt.GetMembershipInformation(getMembershipInformationOptions)
+	if err != nil {
+		return err
+	}
+
+	// cluster_nodes are the nodes the cluster is configured with;
+	// all_nodes are the nodes currently connected.
+	known := map[string]bool{}
+	connected := map[string]bool{}
+	nodes := map[string]bool{}
+	for _, n := range membershipResult.ClusterNodes {
+		known[n] = true
+		nodes[n] = true
+	}
+	for _, n := range membershipResult.AllNodes {
+		connected[n] = true
+		nodes[n] = true
+	}
+
+	for n := range nodes {
+		clusterNodeKnown.WithLabelValues(n).Set(boolToFloat(known[n]))
+		clusterNodeConnected.WithLabelValues(n).Set(boolToFloat(connected[n]))
+	}
+	logger(mm).Info("Read cluster membership", "known", len(known), "connected", len(connected))
+
+	return nil
+}
+
+func boolToFloat(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,95 @@
+package monitors
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+	"google.golang.org/protobuf/proto"
+)
+
+// NodePrometheusMonitor scrapes each node's native Prometheus endpoint,
+// /_node/{node}/_prometheus, which is available from CouchDB 3.2. It is
+// also a prometheus.Gatherer, re-exporting the series it last scraped
+// with an added node label.
+type NodePrometheusMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+
+	mu       sync.Mutex
+	families []*dto.MetricFamily
+}
+
+func (np *NodePrometheusMonitor) Name() string {
+	return "NodePrometheusMonitor"
+}
+
+func (np *NodePrometheusMonitor) Retrieve() error {
+	nodes, err := clusterNodes(np.Cldt)
+	if err != nil {
+		return err
+	}
+
+	// merge each node's series into a single family per metric name
+	merged := map[string]*dto.MetricFamily{}
+	for _, node := range nodes {
+		families, err := np.scrapeNode(node)
+		if err != nil {
+			return err
+		}
+		for name, mf := range families {
+			for _, m := range mf.Metric {
+				addLabel(m, "node", node)
+			}
+			if existing, ok := merged[name]; ok {
+				existing.Metric = append(existing.Metric, mf.Metric...)
+			} else {
+				merged[name] = mf
+			}
+		}
+	}
+
+	families := make([]*dto.MetricFamily, 0, len(merged))
+	for _, mf := range merged {
+		families = append(families, mf)
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].GetName() < families[j].GetName()
+	})
+	logger(np).Info("Scraped nodes", "families", len(families), "nodes", len(nodes))
+
+	np.mu.Lock()
+	np.families = families
+	np.mu.Unlock()
+
+	return nil
+}
+
+// Gather implements prometheus.Gatherer, returning the series from the
+// latest scrape of every node.
+func (np *NodePrometheusMonitor) Gather() ([]*dto.MetricFamily, error) {
+	np.mu.Lock()
+	defer np.mu.Unlock()
+	return np.families, nil
+}
+
+func (np *NodePrometheusMonitor) scrapeNode(node string) (map[string]*dto.MetricFamily, error) {
+	body, err := getText(np.Cldt, "GetNodePrometheus", `/_node/{node}/_prometheus`, map[string]string{"node": node})
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(body)
+}
+
+// addLabel adds the label name=value to m, keeping its labels sorted
+// by name, as the Prometheus registry requires.
+func addLabel(m *dto.Metric, name, value string) {
+	m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+	sort.Slice(m.Label, func(i, j int) bool {
+		return m.Label[i].GetName() < m.Label[j].GetName()
+	})
+}
@@ -0,0 +1,168 @@
+package monitors
+
+import (
+	"strconv"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"cloudant.com/cloudant_exporter/internal/utils"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NodeStatsMonitor exports statistics from each cluster node's
+// /_node/{node}/_stats endpoint. This is only available on CouchDB
+// and Cloudant dedicated clusters.
+type NodeStatsMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+}
+
+var (
+	nodeHttpdRequests = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
+		Name: "cloudant_node_httpd_requests_total",
+		Help: "The number of HTTP requests handled by the node",
+	},
+		[]string{"node"},
+	))
+	nodeHttpdRequestMethods = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
+		Name: "cloudant_node_httpd_request_methods_total",
+		Help: "The number of HTTP requests handled by the node, by method",
+	},
+		[]string{"node", "method"},
+	))
+	nodeHttpdStatusCodes = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
+		Name: "cloudant_node_httpd_status_codes_total",
+		Help: "The number of HTTP responses sent by the node, by status code",
+	},
+		[]string{"node", "code"},
+	))
+	nodeDatabaseReads = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
+		Name: "cloudant_node_database_reads_total",
+		Help: "The number of times a document was read from a database on the node",
+	},
+		[]string{"node"},
+	))
+	nodeDatabaseWrites = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
+		Name: "cloudant_node_database_writes_total",
+		Help: "The number of times a database on the node was changed",
+	},
+		[]string{"node"},
+	))
+	// CouchDB reports these as counters, but they go up
+	// and down as files are opened and closed.
+	nodeOpenDatabases = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_node_open_databases",
+		Help: "The number of database shards open on the node",
+	},
+		[]string{"node"},
+	)
+	nodeOpenOsFiles = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_node_open_os_files",
+		Help: "The number of file descriptors the node has open",
+	},
+		[]string{"node"},
+	)
+	// CouchDB keeps request_time as a histogram over a sliding window,
+	// reporting percentiles rather than buckets, so we can't export it
+	// as a Prometheus histogram.
+	nodeRequestTime = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_node_request_time_seconds",
+		Help: "The node's request latency percentiles, over CouchDB's stats window",
+	},
+		[]string{"node", "quantile"},
+	)
+	nodeRequestTimeMean = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_node_request_time_mean_seconds",
+		Help: "The node's mean request latency, over CouchDB's stats window",
+	},
+		[]string{"node"},
+	)
+)
+
+// statValue is a single counter or gauge from _stats.
+type statValue struct {
+	Value float64 `json:"value"`
+}
+
+// statHistogram is a histogram from _stats, with times in milliseconds.
+type statHistogram struct {
+	Value struct {
+		ArithmeticMean float64 `json:"arithmetic_mean"`
+		// pairs of percentile and value, where 999 is the 99.9th
+		Percentile [][2]float64 `json:"percentile"`
+	} `json:"value"`
+}
+
+// nodeStats is the subset of /_node/{node}/_stats that we export.
+type nodeStats struct {
+	Couchdb struct {
+		DatabaseReads  statValue `json:"database_reads"`
+		DatabaseWrites statValue `json:"database_writes"`
+		Httpd          struct {
+			Requests statValue `json:"requests"`
+		} `json:"httpd"`
+		HttpdRequestMethods map[string]statValue `json:"httpd_request_methods"`
+		HttpdStatusCodes    map[string]statValue `json:"httpd_status_codes"`
+		RequestTime         statHistogram        `json:"request_time"`
+		OpenDatabases       statValue            `json:"open_databases"`
+		OpenOsFiles         statValue            `json:"open_os_files"`
+	} `json:"couchdb"`
+}
+
+func (ns *NodeStatsMonitor) Name() string {
+	return "NodeStatsMonitor"
+}
+
+func (ns *NodeStatsMonitor) Retrieve() error {
+	nodes, err := clusterNodes(ns.Cldt)
+	if err != nil {
+		return err
+	}
+
+	for _, node := range nodes {
+		stats := &nodeStats{}
+		err := getJSON(ns.Cldt, "GetNodeStats", `/_node/{node}/_stats`, map[string]string{"node": node}, nil, stats)
+		if err != nil {
+			return err
+		}
+
+		logger(ns).Debug("Read node statistics", "node", node, "requests", stats.Couchdb.Httpd.Requests.Value)
+		nodeHttpdRequests.WithLabelValues(node).Set(stats.Couchdb.Httpd.Requests.Value)
+		for method, v := range stats.Couchdb.HttpdRequestMethods {
+			nodeHttpdRequestMethods.WithLabelValues(node, method).Set(v.Value)
+		}
+		for code, v := range stats.Couchdb.HttpdStatusCodes {
+			nodeHttpdStatusCodes.WithLabelValues(node, code).Set(v.Value)
+		}
+		nodeDatabaseReads.WithLabelValues(node).Set(stats.Couchdb.DatabaseReads.Value)
+		nodeDatabaseWrites.WithLabelValues(node).Set(stats.Couchdb.DatabaseWrites.Value)
+		nodeOpenDatabases.WithLabelValues(node).Set(stats.Couchdb.OpenDatabases.Value)
+		nodeOpenOsFiles.WithLabelValues(node).Set(stats.Couchdb.OpenOsFiles.Value)
+		for _, p := range stats.Couchdb.RequestTime.Value.Percentile {
+			nodeRequestTime.WithLabelValues(node, quantile(p[0])).Set(p[1] / 1000)
+		}
+		nodeRequestTimeMean.WithLabelValues(node).Set(stats.Couchdb.RequestTime.Value.ArithmeticMean / 1000)
+	}
+
+	return nil
+}
+
+// quantile converts a _stats percentile such as 95 or 999
+// (the 99.9th) into a quantile label such as "0.95" or "0.999".
+func quantile(percentile float64) string {
+	q := percentile / 100
+	if percentile > 100 {
+		q = percentile / 1000
+	}
+	return strconv.FormatFloat(q, 'f', -1, 64)
+}
+
+// clusterNodes returns the names of the nodes that are
+// part of the cluster, from GET /_membership.
+func clusterNodes(cldt *cloudantv1.CloudantV1) ([]string, error) {
+	getMembershipInformationOptions := cldt.NewGetMembershipInformationOptions()
+	membershipResult, _, err := cldt.GetMembershipInformation(getMembershipInformationOptions)
+	if err != nil {
+		return nil, err
+	}
+	return membershipResult.ClusterNodes, nil
+}
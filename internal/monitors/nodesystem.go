@@ -0,0 +1,227 @@
+package monitors
+
+import (
+	"encoding/json"
+	"sort"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"cloudant.com/cloudant_exporter/internal/utils"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// NodeSystemMonitor exports Erlang VM metrics from each cluster node's
+// /_node/{node}/_system endpoint. This is only available on CouchDB
+// and Cloudant dedicated clusters.
+type NodeSystemMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+
+	// MessageQueuesTopK limits the message queue metrics to
+	// the K longest queues on each node.
+	MessageQueuesTopK int
+}
+
+var (
+	nodeUptimeSeconds = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_node_uptime_seconds",
+		Help: "The time since the node's Erlang VM started, in seconds",
+	},
+		[]string{"node"},
+	)
+	nodeMemoryBytes = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_node_memory_bytes",
+		Help: "The total memory allocated by the node's Erlang VM, in bytes",
+	},
+		[]string{"node"},
+	)
+	nodeMemoryAreaBytes = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_node_memory_area_bytes",
+		Help: "The memory allocated by the node's Erlang VM, by area (eg, processes, binary, ets, atom), in bytes",
+	},
+		[]string{"node", "area"},
+	)
+	nodeProcessCount = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_node_process_count",
+		Help: "The number of Erlang processes on the node",
+	},
+		[]string{"node"},
+	)
+	nodeProcessLimit = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_node_process_limit",
+		Help: "The maximum number of Erlang processes allowed on the node",
+	},
+		[]string{"node"},
+	)
+	nodeRunQueue = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_node_run_queue",
+		Help: "The number of Erlang processes ready to run on the node",
+	},
+		[]string{"node"},
+	)
+	nodeOsProcCount = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_node_os_proc_count",
+		Help: "The number of OS processes (eg, JavaScript query servers) run by the node",
+	},
+		[]string{"node"},
+	)
+	nodeEtsTableCount = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_node_ets_table_count",
+		Help: "The number of ETS tables on the node",
+	},
+		[]string{"node"},
+	)
+	nodeInternalReplicationJobs = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_node_internal_replication_jobs",
+		Help: "The number of internal (shard) replication jobs on the node",
+	},
+		[]string{"node"},
+	)
+	// This is reset on every poll, as the longest queues change.
+	nodeMessageQueueLength = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_node_message_queue_length",
+		Help: "The number of messages waiting in the process's message queue, for the longest queues on the node",
+	},
+		[]string{"node", "process"},
+	)
+	nodeContextSwitches = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
+		Name: "cloudant_node_context_switches_total",
+		Help: "The number of Erlang context switches on the node",
+	},
+		[]string{"node"},
+	))
+	nodeReductions = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
+		Name: "cloudant_node_reductions_total",
+		Help: "The number of Erlang reductions on the node",
+	},
+		[]string{"node"},
+	))
+	nodeGarbageCollections = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
+		Name: "cloudant_node_garbage_collections_total",
+		Help: "The number of Erlang garbage collections on the node",
+	},
+		[]string{"node"},
+	))
+	nodeIOInputBytes = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
+		Name: "cloudant_node_io_input_bytes_total",
+		Help: "The number of bytes received through ports by the node",
+	},
+		[]string{"node"},
+	))
+	nodeIOOutputBytes = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
+		Name: "cloudant_node_io_output_bytes_total",
+		Help: "The number of bytes sent through ports by the node",
+	},
+		[]string{"node"},
+	))
+)
+
+// nodeSystem is the subset of /_node/{node}/_system that we export.
+type nodeSystem struct {
+	Uptime                  float64            `json:"uptime"`
+	Memory                  map[string]float64 `json:"memory"`
+	RunQueue                float64            `json:"run_queue"`
+	EtsTableCount           float64            `json:"ets_table_count"`
+	ContextSwitches         float64            `json:"context_switches"`
+	Reductions              float64            `json:"reductions"`
+	GarbageCollectionCount  float64            `json:"garbage_collection_count"`
+	IOInput                 float64            `json:"io_input"`
+	IOOutput                float64            `json:"io_output"`
+	OsProcCount             float64            `json:"os_proc_count"`
+	ProcessCount            float64            `json:"process_count"`
+	ProcessLimit            float64            `json:"process_limit"`
+	InternalReplicationJobs float64            `json:"internal_replication_jobs"`
+	// Each queue is either a length, or a summary of the
+	// lengths of a group of processes.
+	MessageQueues map[string]json.RawMessage `json:"message_queues"`
+}
+
+// messageQueue is the length of a process's message queue.
+type messageQueue struct {
+	process string
+	length  float64
+}
+
+// messageQueues returns the node's message queues, longest first. For
+// a group of processes, such as couch_file, the longest queue in the
+// group is used.
+func (s *nodeSystem) messageQueues() []messageQueue {
+	queues := []messageQueue{}
+	for process, raw := range s.MessageQueues {
+		var length float64
+		if err := json.Unmarshal(raw, &length); err != nil {
+			group := struct {
+				Max float64 `json:"max"`
+			}{}
+			if err := json.Unmarshal(raw, &group); err != nil {
+				continue
+			}
+			length = group.Max
+		}
+		queues = append(queues, messageQueue{process: process, length: length})
+	}
+	sort.Slice(queues, func(i, j int) bool {
+		return queues[i].length > queues[j].length
+	})
+	return queues
+}
+
+// memoryTotal sums the memory areas that make up the Erlang VM's
+// total, as erlang:memory(total) would. The *_used areas are
+// subsets of other areas, so aren't included.
+func (s *nodeSystem) memoryTotal() float64 {
+	total := 0.0
+	for _, area := range []string{"processes", "atom", "binary", "code", "ets", "other"} {
+		total += s.Memory[area]
+	}
+	return total
+}
+
+func (nsm *NodeSystemMonitor) Name() string {
+	return "NodeSystemMonitor"
+}
+
+func (nsm *NodeSystemMonitor) Retrieve() error {
+	nodes, err := clusterNodes(nsm.Cldt)
+	if err != nil {
+		return err
+	}
+
+	queued := map[string]bool{}
+	for _, node := range nodes {
+		sys := &nodeSystem{}
+		err := getJSON(nsm.Cldt, "GetNodeSystem", `/_node/{node}/_system`, map[string]string{"node": node}, nil, sys)
+		if err != nil {
+			return err
+		}
+
+		logger(nsm).Debug("Read node system statistics", "node", node, "processes", sys.ProcessCount, "run_queue", sys.RunQueue)
+		nodeUptimeSeconds.WithLabelValues(node).Set(sys.Uptime)
+		nodeMemoryBytes.WithLabelValues(node).Set(sys.memoryTotal())
+		for area, bytes := range sys.Memory {
+			nodeMemoryAreaBytes.WithLabelValues(node, area).Set(bytes)
+		}
+		nodeProcessCount.WithLabelValues(node).Set(sys.ProcessCount)
+		nodeProcessLimit.WithLabelValues(node).Set(sys.ProcessLimit)
+		nodeRunQueue.WithLabelValues(node).Set(sys.RunQueue)
+		nodeOsProcCount.WithLabelValues(node).Set(sys.OsProcCount)
+		nodeEtsTableCount.WithLabelValues(node).Set(sys.EtsTableCount)
+		nodeInternalReplicationJobs.WithLabelValues(node).Set(sys.InternalReplicationJobs)
+		queues := sys.messageQueues()
+		if nsm.MessageQueuesTopK > 0 && len(queues) > nsm.MessageQueuesTopK {
+			queues = queues[:nsm.MessageQueuesTopK]
+		}
+		for _, q := range queues {
+			nodeMessageQueueLength.WithLabelValues(node, q.process).Set(q.length)
+			queued[utils.LabelsKey(node, q.process)] = true
+		}
+		nodeContextSwitches.WithLabelValues(node).Set(sys.ContextSwitches)
+		nodeReductions.WithLabelValues(node).Set(sys.Reductions)
+		nodeGarbageCollections.WithLabelValues(node).Set(sys.GarbageCollectionCount)
+		nodeIOInputBytes.WithLabelValues(node).Set(sys.IOInput)
+		nodeIOOutputBytes.WithLabelValues(node).Set(sys.IOOutput)
+	}
+	// processes which have left the longest queues, or exited
+	utils.DeleteStale(nodeMessageQueueLength.MetricVec, utils.KeepLabels([]string{"node", "process"}, queued))
+
+	return nil
+}
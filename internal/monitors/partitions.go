@@ -0,0 +1,92 @@
+package monitors
+
+import (
+	"fmt"
+	"strings"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Partition identifies a single partition of a partitioned database.
+type Partition struct {
+	Database string
+	Key      string
+}
+
+// ParsePartition parses a partition given as "database:partition".
+func ParsePartition(s string) (Partition, error) {
+	db, key, ok := strings.Cut(s, ":")
+	if !ok || db == "" || key == "" {
+		return Partition{}, fmt.Errorf("invalid partition %q, expected database:partition", s)
+	}
+	return Partition{Database: db, Key: key}, nil
+}
+
+type PartitionsMonitor struct {
+	Cldt       *cloudantv1.CloudantV1
+	Partitions []Partition
+}
+
+var (
+	partitionDocCount = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_partition_doc_count",
+		Help: "The number of documents in the partition",
+	},
+		[]string{"database", "partition"},
+	)
+	partitionDeletedDocCount = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_partition_deleted_doc_count",
+		Help: "The number of deleted documents in the partition",
+	},
+		[]string{"database", "partition"},
+	)
+	partitionActiveSizeBytes = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_partition_active_size_bytes",
+		Help: "The size of live data inside the partition, in bytes",
+	},
+		[]string{"database", "partition"},
+	)
+	partitionExternalSizeBytes = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_partition_external_size_bytes",
+		Help: "The uncompressed size of the partition's contents, in bytes",
+	},
+		[]string{"database", "partition"},
+	)
+	partitionIndexTotal = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_partition_index_total",
+		Help: "The number of partitioned indexes in the partition's database",
+	},
+		[]string{"database", "partition"},
+	)
+)
+
+func (pm *PartitionsMonitor) Name() string {
+	return "PartitionsMonitor"
+}
+
+func (pm *PartitionsMonitor) Retrieve() error {
+	for _, p := range pm.Partitions {
+		getPartitionInformationOptions := pm.Cldt.NewGetPartitionInformationOptions(p.Database, p.Key)
+		partitionInfo, _, err := pm.Cldt.GetPartitionInformation(getPartitionInformationOptions)
+		if err != nil {
+			return err
+		}
+
+		logger(pm).Debug("Read partition info", "database", p.Database, "partition", p.Key, "docs", *partitionInfo.DocCount)
+		partitionDocCount.WithLabelValues(p.Database, p.Key).Set(float64(*partitionInfo.DocCount))
+		partitionDeletedDocCount.WithLabelValues(p.Database, p.Key).Set(float64(*partitionInfo.DocDelCount))
+		if partitionInfo.Sizes.Active != nil {
+			partitionActiveSizeBytes.WithLabelValues(p.Database, p.Key).Set(float64(*partitionInfo.Sizes.Active))
+		}
+		if partitionInfo.Sizes.External != nil {
+			partitionExternalSizeBytes.WithLabelValues(p.Database, p.Key).Set(float64(*partitionInfo.Sizes.External))
+		}
+		if partitionInfo.PartitionedIndexes != nil && partitionInfo.PartitionedIndexes.Count != nil {
+			partitionIndexTotal.WithLabelValues(p.Database, p.Key).Set(float64(*partitionInfo.PartitionedIndexes.Count))
+		}
+	}
+
+	return nil
+}
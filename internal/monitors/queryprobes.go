@@ -0,0 +1,211 @@
+package monitors
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/IBM/go-sdk-core/v5/core"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Query probes run a configured query periodically, timing it and
+// recording how many results it returned. Like the CanaryMonitor,
+// failures are reported in metrics rather than causing an exit.
+var (
+	queryProbeDuration = registry.Auto.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "cloudant_query_probe_duration_seconds",
+		Help: "Time taken to run the probe's query",
+	},
+		[]string{"probe", "kind"},
+	)
+	queryProbeResults = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_query_probe_results",
+		Help: "The number of results returned by the probe's last query, up to 1000 for find probes",
+	},
+		[]string{"probe", "kind"},
+	)
+	queryProbeErrors = registry.Auto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloudant_query_probe_errors_total",
+		Help: "The number of times the probe's query failed",
+	},
+		[]string{"probe", "kind"},
+	)
+	queryProbeResponses = registry.Auto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloudant_query_probe_responses_total",
+		Help: "The number of responses to the probe's query, by HTTP status code",
+	},
+		[]string{"probe", "kind", "code"},
+	)
+)
+
+// findProbeLimit caps the documents a find probe returns, and so its
+// result count. _find would otherwise stop at its default of 25.
+const findProbeLimit = 1000
+
+// FindProbe is a Cloudant Query (_find) query to run periodically.
+type FindProbe struct {
+	Name     string
+	Database string
+	Selector map[string]interface{}
+}
+
+// ParseFindProbe parses a probe given as "name,database,selector",
+// where the selector is JSON.
+func ParseFindProbe(s string) (FindProbe, error) {
+	parts := strings.SplitN(s, ",", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" {
+		return FindProbe{}, fmt.Errorf("invalid find probe %q, expected name,database,selector", s)
+	}
+	selector := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(parts[2]), &selector); err != nil {
+		return FindProbe{}, fmt.Errorf("invalid selector for find probe %q: %w", parts[0], err)
+	}
+	return FindProbe{Name: parts[0], Database: parts[1], Selector: selector}, nil
+}
+
+type FindProbesMonitor struct {
+	Cldt   *cloudantv1.CloudantV1
+	Probes []FindProbe
+}
+
+func (fp *FindProbesMonitor) Name() string {
+	return "FindProbesMonitor"
+}
+
+func (fp *FindProbesMonitor) Retrieve() error {
+	for _, p := range fp.Probes {
+		postFindOptions := fp.Cldt.NewPostFindOptions(p.Database, p.Selector)
+		postFindOptions.SetLimit(findProbeLimit)
+
+		start := time.Now()
+		findResult, response, err := fp.Cldt.PostFind(postFindOptions)
+		queryProbeResponses.WithLabelValues(p.Name, "find", responseCode(response)).Inc()
+		if err != nil {
+			logger(fp).Warn("Probe failed", "probe", p.Name, "err", err)
+			queryProbeErrors.WithLabelValues(p.Name, "find").Inc()
+			continue
+		}
+		queryProbeDuration.WithLabelValues(p.Name, "find").Observe(time.Since(start).Seconds())
+		queryProbeResults.WithLabelValues(p.Name, "find").Set(float64(len(findResult.Docs)))
+	}
+
+	return nil
+}
+
+// ViewProbe is a view to query periodically.
+type ViewProbe struct {
+	Name     string
+	Database string
+	// DesignDoc is the design document's name, without the _design/ prefix.
+	DesignDoc string
+	View      string
+}
+
+// ParseViewProbe parses a probe given as "name,database/ddoc/view".
+func ParseViewProbe(s string) (ViewProbe, error) {
+	name, path, _ := strings.Cut(s, ",")
+	parts := strings.Split(path, "/")
+	if name == "" || len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return ViewProbe{}, fmt.Errorf("invalid view probe %q, expected name,database/ddoc/view", s)
+	}
+	return ViewProbe{Name: name, Database: parts[0], DesignDoc: parts[1], View: parts[2]}, nil
+}
+
+type ViewProbesMonitor struct {
+	Cldt   *cloudantv1.CloudantV1
+	Probes []ViewProbe
+}
+
+func (vp *ViewProbesMonitor) Name() string {
+	return "ViewProbesMonitor"
+}
+
+func (vp *ViewProbesMonitor) Retrieve() error {
+	for _, p := range vp.Probes {
+		// We only want the view to be brought up to date, and its
+		// total_rows, not the rows themselves.
+		postViewOptions := vp.Cldt.NewPostViewOptions(p.Database, p.DesignDoc, p.View)
+		postViewOptions.SetLimit(0)
+
+		start := time.Now()
+		viewResult, response, err := vp.Cldt.PostView(postViewOptions)
+		queryProbeResponses.WithLabelValues(p.Name, "view", responseCode(response)).Inc()
+		if err != nil {
+			logger(vp).Warn("Probe failed", "probe", p.Name, "err", err)
+			queryProbeErrors.WithLabelValues(p.Name, "view").Inc()
+			continue
+		}
+		queryProbeDuration.WithLabelValues(p.Name, "view").Observe(time.Since(start).Seconds())
+		if viewResult.TotalRows != nil {
+			queryProbeResults.WithLabelValues(p.Name, "view").Set(float64(*viewResult.TotalRows))
+		}
+	}
+
+	return nil
+}
+
+// SearchProbe is a Cloudant Search query to run periodically.
+type SearchProbe struct {
+	Name     string
+	Database string
+	// DesignDoc is the design document's name, without the _design/ prefix.
+	DesignDoc string
+	Index     string
+	Query     string
+}
+
+// ParseSearchProbe parses a probe given as "name,database/ddoc/index,query".
+func ParseSearchProbe(s string) (SearchProbe, error) {
+	parts := strings.SplitN(s, ",", 3)
+	if len(parts) != 3 || parts[0] == "" || parts[2] == "" {
+		return SearchProbe{}, fmt.Errorf("invalid search probe %q, expected name,database/ddoc/index,query", s)
+	}
+	path := strings.Split(parts[1], "/")
+	if len(path) != 3 || path[0] == "" || path[1] == "" || path[2] == "" {
+		return SearchProbe{}, fmt.Errorf("invalid search probe %q, expected name,database/ddoc/index,query", s)
+	}
+	return SearchProbe{Name: parts[0], Database: path[0], DesignDoc: path[1], Index: path[2], Query: parts[2]}, nil
+}
+
+type SearchProbesMonitor struct {
+	Cldt   *cloudantv1.CloudantV1
+	Probes []SearchProbe
+}
+
+func (sp *SearchProbesMonitor) Name() string {
+	return "SearchProbesMonitor"
+}
+
+func (sp *SearchProbesMonitor) Retrieve() error {
+	for _, p := range sp.Probes {
+		postSearchOptions := sp.Cldt.NewPostSearchOptions(p.Database, p.DesignDoc, p.Index, p.Query)
+		postSearchOptions.SetLimit(1)
+
+		start := time.Now()
+		searchResult, response, err := sp.Cldt.PostSearch(postSearchOptions)
+		queryProbeResponses.WithLabelValues(p.Name, "search", responseCode(response)).Inc()
+		if err != nil {
+			logger(sp).Warn("Probe failed", "probe", p.Name, "err", err)
+			queryProbeErrors.WithLabelValues(p.Name, "search").Inc()
+			continue
+		}
+		queryProbeDuration.WithLabelValues(p.Name, "search").Observe(time.Since(start).Seconds())
+		queryProbeResults.WithLabelValues(p.Name, "search").Set(float64(*searchResult.TotalRows))
+	}
+
+	return nil
+}
+
+// responseCode returns the HTTP status code of response as a label
+// value, or "none" if the request failed without a response.
+func responseCode(response *core.DetailedResponse) string {
+	if response == nil || response.StatusCode == 0 {
+		return "none"
+	}
+	return strconv.Itoa(response.StatusCode)
+}
@@ -0,0 +1,117 @@
+package monitors
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseFindProbe(t *testing.T) {
+	tests := []struct {
+		in   string
+		want FindProbe
+		err  bool
+	}{
+		{
+			in:   `orders,orders-db,{"status":"open"}`,
+			want: FindProbe{Name: "orders", Database: "orders-db", Selector: map[string]interface{}{"status": "open"}},
+		},
+		{
+			// the selector can contain commas
+			in:   `both,db,{"a":1,"b":2}`,
+			want: FindProbe{Name: "both", Database: "db", Selector: map[string]interface{}{"a": 1.0, "b": 2.0}},
+		},
+		{in: `orders,orders-db`, err: true},
+		{in: `,orders-db,{}`, err: true},
+		{in: `orders,,{}`, err: true},
+		{in: `orders,orders-db,not json`, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseFindProbe(tt.in)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseViewProbe(t *testing.T) {
+	tests := []struct {
+		in   string
+		want ViewProbe
+		err  bool
+	}{
+		{in: "recent,orders/reports/by_date", want: ViewProbe{Name: "recent", Database: "orders", DesignDoc: "reports", View: "by_date"}},
+		{in: "recent", err: true},
+		{in: ",orders/reports/by_date", err: true},
+		{in: "recent,orders/reports", err: true},
+		{in: "recent,orders/reports/by_date/extra", err: true},
+		{in: "recent,orders//by_date", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseViewProbe(tt.in)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSearchProbe(t *testing.T) {
+	tests := []struct {
+		in   string
+		want SearchProbe
+		err  bool
+	}{
+		{
+			in:   "names,customers/search/by_name,name:smith",
+			want: SearchProbe{Name: "names", Database: "customers", DesignDoc: "search", Index: "by_name", Query: "name:smith"},
+		},
+		{
+			// the query can contain commas
+			in:   "names,customers/search/by_name,name:smith,jones",
+			want: SearchProbe{Name: "names", Database: "customers", DesignDoc: "search", Index: "by_name", Query: "name:smith,jones"},
+		},
+		{in: "names,customers/search/by_name", err: true},
+		{in: ",customers/search/by_name,q", err: true},
+		{in: "names,customers/search/by_name,", err: true},
+		{in: "names,customers/by_name,q", err: true},
+		{in: "names,/search/by_name,q", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			got, err := ParseSearchProbe(tt.in)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,150 @@
+package monitors
+
+import (
+	"time"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"cloudant.com/cloudant_exporter/internal/utils"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type ReplicationJobsMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+
+	// lastStarted holds the time of the latest "started" event
+	// we've seen for each replication, keyed by its replicator
+	// database and doc ID, so we only count new ones.
+	lastStarted map[string]time.Time
+}
+
+var (
+	// The scheduler only keeps a short history of events for each job,
+	// so this counts the errors in that window rather than since creation.
+	historyErrorsTotal = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_replication_history_errors_total",
+		Help: "The number of crashes in the replication job's recent history",
+	},
+		[]string{"database", "docid"},
+	)
+	lastCrashTimestamp = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_replication_last_crash_timestamp_seconds",
+		Help: "Unix time of the most recent crash in the replication job's history",
+	},
+		[]string{"database", "docid"},
+	)
+	// We count restarts ourselves, so this can be a real counter.
+	restartsTotal = registry.Auto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloudant_replication_restarts_total",
+		Help: "The number of times the replication job has been (re)started since the exporter began watching it",
+	},
+		[]string{"database", "docid"},
+	)
+)
+
+func (rj *ReplicationJobsMonitor) Name() string {
+	return "ReplicationJobsMonitor"
+}
+
+func (rj *ReplicationJobsMonitor) Retrieve() error {
+	jobs, err := schedulerJobs(rj.Cldt)
+	if err != nil {
+		return err
+	}
+	if rj.lastStarted == nil {
+		rj.lastStarted = map[string]time.Time{}
+	}
+
+	current := map[string]bool{}
+	for _, j := range jobs {
+		db, docID := jobDatabase(j), jobDocID(j)
+		key := utils.LabelsKey(db, docID)
+		current[key] = true
+		crashes := 0
+		var lastCrash time.Time
+		prevStarted, seen := rj.lastStarted[key]
+		latestStarted := prevStarted
+		restarts := 0
+		for _, e := range j.History {
+			t := time.Time(*e.Timestamp)
+			switch *e.Type {
+			case "crashed":
+				crashes++
+				if t.After(lastCrash) {
+					lastCrash = t
+				}
+			case "started":
+				if t.After(prevStarted) {
+					restarts++
+				}
+				if t.After(latestStarted) {
+					latestStarted = t
+				}
+			}
+		}
+		rj.lastStarted[key] = latestStarted
+		// the first time we see a job, its existing starts aren't restarts
+		restartsCounter := restartsTotal.WithLabelValues(db, docID)
+		if seen {
+			restartsCounter.Add(float64(restarts))
+		}
+		historyErrorsTotal.WithLabelValues(db, docID).Set(float64(crashes))
+		if !lastCrash.IsZero() {
+			lastCrashTimestamp.WithLabelValues(db, docID).Set(float64(lastCrash.Unix()))
+		}
+	}
+	// jobs which have finished or been deleted
+	for key := range rj.lastStarted {
+		if !current[key] {
+			delete(rj.lastStarted, key)
+		}
+	}
+	keep := utils.KeepLabels([]string{"database", "docid"}, current)
+	utils.DeleteStale(historyErrorsTotal.MetricVec, keep)
+	utils.DeleteStale(lastCrashTimestamp.MetricVec, keep)
+	utils.DeleteStale(restartsTotal.MetricVec, keep)
+	logger(rj).Info("Retrieved scheduler jobs", "jobs", len(jobs))
+
+	return nil
+}
+
+// schedulerJobs pages through GET /_scheduler/jobs returning every job.
+func schedulerJobs(cldt *cloudantv1.CloudantV1) ([]cloudantv1.SchedulerJob, error) {
+	var batchSize int = 100
+	jobs := []cloudantv1.SchedulerJob{}
+	getSchedulerJobsOptions := cldt.NewGetSchedulerJobsOptions()
+	getSchedulerJobsOptions.SetLimit(int64(batchSize))
+
+	// repeat until we get a smaller batch than we asked for
+	for {
+		getSchedulerJobsOptions.SetSkip(int64(len(jobs)))
+		schedulerJobsResult, _, err := cldt.GetSchedulerJobs(getSchedulerJobsOptions)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, schedulerJobsResult.Jobs...)
+		if len(schedulerJobsResult.Jobs) < batchSize {
+			break
+		}
+	}
+
+	return jobs, nil
+}
+
+// jobDocID returns the _replicator document ID for the job, or the job's
+// own ID for replications started via POST /_replicate.
+func jobDocID(j cloudantv1.SchedulerJob) string {
+	if j.DocID != nil {
+		return *j.DocID
+	}
+	return *j.ID
+}
+
+// jobDatabase returns the replicator database holding the job's
+// document, or "" for replications started via POST /_replicate.
+func jobDatabase(j cloudantv1.SchedulerJob) string {
+	if j.Database != nil {
+		return *j.Database
+	}
+	return ""
+}
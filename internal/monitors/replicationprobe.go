@@ -0,0 +1,190 @@
+package monitors
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/IBM/go-sdk-core/v5/core"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// replicationProbeDocID is the ID of the document the probe updates
+// in the source database before each replication.
+const replicationProbeDocID = "cloudant_exporter_replication_probe"
+
+// replicationProbeTimeout is how long we wait for a probe replication to complete.
+const replicationProbeTimeout = 2 * time.Minute
+
+// replicationProbeCleanupAttempts is how many times we try to delete the
+// replication document, which the replicator may update as we do.
+const replicationProbeCleanupAttempts = 3
+
+// errConflict marks the errors of 409 responses, when the document
+// was updated by someone else since we read it.
+var errConflict = errors.New("conflict")
+
+// ReplicationProbeMonitor runs a one-shot replication from Source to Target
+// and times how long it takes to complete. Like the CanaryMonitor, failures
+// are reported in metrics rather than causing the monitor to exit.
+type ReplicationProbeMonitor struct {
+	Cldt   *cloudantv1.CloudantV1
+	Source string
+	Target string
+
+	// Auth is the authentication the replicator should use for both
+	// databases, as it can't use the exporter's own client.
+	Auth *cloudantv1.ReplicationDatabaseAuth
+}
+
+var (
+	replicationProbeDuration = registry.Auto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudant_replication_probe_duration_seconds",
+		Help: "Time taken for the last successful probe replication to complete",
+	})
+	replicationProbeSuccesses = registry.Auto.NewCounter(prometheus.CounterOpts{
+		Name: "cloudant_replication_probe_successes_total",
+		Help: "The number of probe replications which completed",
+	})
+	replicationProbeFailures = registry.Auto.NewCounter(prometheus.CounterOpts{
+		Name: "cloudant_replication_probe_failures_total",
+		Help: "The number of probe replications which failed or timed out",
+	})
+	// The replication document holds the credentials in Auth, so
+	// one left behind is worth knowing about.
+	replicationProbeCleanupFailures = registry.Auto.NewCounter(prometheus.CounterOpts{
+		Name: "cloudant_replication_probe_cleanup_failures_total",
+		Help: "The number of probe replication documents which could not be deleted",
+	})
+)
+
+func (rp *ReplicationProbeMonitor) Name() string {
+	return "ReplicationProbeMonitor"
+}
+
+func (rp *ReplicationProbeMonitor) Retrieve() error {
+	d, err := rp.probe()
+	if err != nil {
+		logger(rp).Warn("Probe failed", "err", err)
+		replicationProbeFailures.Inc()
+		return nil
+	}
+
+	logger(rp).Info("Probe replication completed", "replication_duration_seconds", d.Seconds())
+	replicationProbeDuration.Set(d.Seconds())
+	replicationProbeSuccesses.Inc()
+	return nil
+}
+
+// probe writes a document to the source database, so the replication
+// has something to do, then replicates it to the target.
+func (rp *ReplicationProbeMonitor) probe() (time.Duration, error) {
+	err := rp.touchSourceDocument()
+	if err != nil {
+		return 0, err
+	}
+
+	docID := fmt.Sprintf("cloudant_exporter_probe_%d", time.Now().UnixNano())
+	replicationDoc := &cloudantv1.ReplicationDocument{
+		Source:     &cloudantv1.ReplicationDatabase{URL: rp.dbURL(rp.Source), Auth: rp.Auth},
+		Target:     &cloudantv1.ReplicationDatabase{URL: rp.dbURL(rp.Target), Auth: rp.Auth},
+		Continuous: core.BoolPtr(false),
+	}
+	putReplicationDocumentOptions := rp.Cldt.NewPutReplicationDocumentOptions(docID, replicationDoc)
+
+	start := time.Now()
+	_, _, err = rp.Cldt.PutReplicationDocument(putReplicationDocumentOptions)
+	if err != nil {
+		return 0, err
+	}
+	defer rp.cleanup(docID)
+
+	for time.Since(start) < replicationProbeTimeout {
+		if err := sleep(1 * time.Second); err != nil {
+			return 0, err
+		}
+
+		getSchedulerDocumentOptions := rp.Cldt.NewGetSchedulerDocumentOptions(docID)
+		schedulerDoc, resp, err := rp.Cldt.GetSchedulerDocument(getSchedulerDocumentOptions)
+		if err != nil {
+			if resp != nil && resp.StatusCode == http.StatusNotFound {
+				// the replicator hasn't picked up the document yet
+				continue
+			}
+			return 0, err
+		}
+		switch *schedulerDoc.State {
+		case "completed":
+			return time.Since(start), nil
+		case "failed":
+			return 0, fmt.Errorf("probe replication failed")
+		}
+	}
+
+	return 0, fmt.Errorf("probe replication didn't complete within %s", replicationProbeTimeout)
+}
+
+// touchSourceDocument creates or updates the probe document in the source database.
+func (rp *ReplicationProbeMonitor) touchSourceDocument() error {
+	doc := &cloudantv1.Document{}
+	getDocumentOptions := rp.Cldt.NewGetDocumentOptions(rp.Source, replicationProbeDocID)
+	existing, resp, err := rp.Cldt.GetDocument(getDocumentOptions)
+	if err != nil && (resp == nil || resp.StatusCode != http.StatusNotFound) {
+		return err
+	}
+	if existing != nil {
+		doc.Rev = existing.Rev
+	}
+
+	doc.SetProperty("written", time.Now().Unix())
+	putDocumentOptions := rp.Cldt.NewPutDocumentOptions(rp.Source, replicationProbeDocID)
+	putDocumentOptions.SetDocument(doc)
+	_, _, err = rp.Cldt.PutDocument(putDocumentOptions)
+	return err
+}
+
+// cleanup deletes the probe's replication document. The replicator
+// records its state in the document, changing its rev from the one we
+// created, so we read the current rev and retry if it changes again.
+func (rp *ReplicationProbeMonitor) cleanup(docID string) {
+	var err error
+	for attempt := 0; attempt < replicationProbeCleanupAttempts; attempt++ {
+		err = rp.deleteReplicationDocument(docID)
+		if !errors.Is(err, errConflict) {
+			break
+		}
+	}
+	if err != nil {
+		logger(rp).Warn("Could not delete replication document", "docid", docID, "err", err)
+		replicationProbeCleanupFailures.Inc()
+	}
+}
+
+// deleteReplicationDocument deletes the latest rev of docID, returning
+// errConflict if it was updated between reading and deleting it.
+func (rp *ReplicationProbeMonitor) deleteReplicationDocument(docID string) error {
+	getReplicationDocumentOptions := rp.Cldt.NewGetReplicationDocumentOptions(docID)
+	doc, _, err := rp.Cldt.GetReplicationDocument(getReplicationDocumentOptions)
+	if err != nil {
+		return err
+	}
+
+	deleteReplicationDocumentOptions := rp.Cldt.NewDeleteReplicationDocumentOptions(docID)
+	deleteReplicationDocumentOptions.SetRev(*doc.Rev)
+	_, resp, err := rp.Cldt.DeleteReplicationDocument(deleteReplicationDocumentOptions)
+	if err != nil && resp != nil && resp.StatusCode == http.StatusConflict {
+		return fmt.Errorf("%w: %v", errConflict, err)
+	}
+	return err
+}
+
+// dbURL returns the full URL of database db on our Cloudant service.
+func (rp *ReplicationProbeMonitor) dbURL(db string) *string {
+	u := strings.TrimRight(rp.Cldt.GetServiceURL(), "/") + "/" + url.PathEscape(db)
+	return &u
+}
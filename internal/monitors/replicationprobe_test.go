@@ -0,0 +1,53 @@
+package monitors
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/IBM/go-sdk-core/v5/core"
+)
+
+func TestReplicationProbeCleanup(t *testing.T) {
+	// the replicator has updated the document since we created it at
+	// 1-x, and updates it again between our first read and delete
+	rev := 2
+	updated := false
+	deleted := ""
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.Method {
+		case http.MethodGet:
+			fmt.Fprintf(w, `{"_id":"probe","_rev":"%d-x"}`, rev)
+		case http.MethodDelete:
+			if !updated {
+				updated = true
+				rev++
+			}
+			current := fmt.Sprintf("%d-x", rev)
+			if r.URL.Query().Get("rev") != current {
+				w.WriteHeader(http.StatusConflict)
+				fmt.Fprint(w, `{"error":"conflict","reason":"Document update conflict."}`)
+				return
+			}
+			deleted = current
+			fmt.Fprintf(w, `{"ok":true,"id":"probe","rev":"%d-x"}`, rev+1)
+		}
+	}))
+	defer srv.Close()
+
+	cldt, err := cloudantv1.NewCloudantV1(&cloudantv1.CloudantV1Options{
+		URL:           srv.URL,
+		Authenticator: &core.NoAuthAuthenticator{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rp := &ReplicationProbeMonitor{Cldt: cldt}
+	rp.cleanup("probe")
+	if deleted != "3-x" {
+		t.Errorf("deleted rev %q, want 3-x", deleted)
+	}
+}
@@ -1,60 +1,137 @@
 package monitors
 
 import (
-	"log"
+	"time"
 
+	"cloudant.com/cloudant_exporter/internal/registry"
 	"cloudant.com/cloudant_exporter/internal/utils"
 	"github.com/IBM/cloudant-go-sdk/cloudantv1"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 type ReplicationProgressMonitor struct {
 	Cldt *cloudantv1.CloudantV1
+
+	// ReplicatorDbs limits the monitor to these replicator databases,
+	// rather than all of them.
+	ReplicatorDbs []string
+
+	// checkpoints tracks the last checkpointed sequence we saw for
+	// each replication, and when we first saw it. Like written and
+	// progressed, it's keyed by the replicator database and doc ID,
+	// as doc IDs are only unique within a replicator database.
+	checkpoints map[string]checkpoint
+
+	// written holds the previous docs_written sample for
+	// each replication, for working out its rate.
+	written map[string]sample
+
+	// StallAfter is how long a replication with changes pending can
+	// go without writing any documents before it counts as stalled.
+	StallAfter time.Duration
+
+	// progressed is when each replication last wrote
+	// documents, or had no changes pending.
+	progressed map[string]time.Time
+
+	// continuous records whether each replication is continuous,
+	// which is only in its replication document, so we read each
+	// document once rather than on every poll.
+	continuous map[string]bool
+}
+
+type sample struct {
+	value int64
+	at    time.Time
+}
+
+type checkpoint struct {
+	seq  string
+	seen time.Time
 }
 
 var (
 	// Changes pending mostly goes down, but can go up if the replication
 	// begins to fall behind. It's definitely a gauge.
-	changesPendingTotal = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	changesPendingTotal = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "cloudant_replication_changes_pending_total",
 		Help: "The number of changes remaining to process (approximately)",
 	},
-		[]string{"docid"},
+		[]string{"database", "docid"},
+	)
+	// Docs pending is the number of missing revisions found on the source
+	// which haven't yet been written (or failed to write) to the target.
+	docsPendingTotal = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_replication_docs_pending_total",
+		Help: "The number of missing revisions found but not yet written to the target (approximately)",
+	},
+		[]string{"database", "docid"},
+	)
+	// The scheduler doesn't tell us when a replication last checkpointed,
+	// so we time how long its checkpointed sequence has been unchanged.
+	secondsSinceLastCheckpoint = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_replication_seconds_since_last_checkpoint",
+		Help: "Seconds since the replication's checkpointed source sequence last changed (approximately)",
+	},
+		[]string{"database", "docid"},
+	)
+
+	docsPerSecond = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_replication_docs_per_second",
+		Help: "The rate at which the replication wrote documents between the last two polls",
+	},
+		[]string{"database", "docid"},
+	)
+	// Source and target are stripped of credentials before use as labels.
+	replicationInfo = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_replication_info",
+		Help: "The replication's source and target; always 1",
+	},
+		[]string{"database", "docid", "source", "target"},
+	)
+
+	// A replication that is running but not writing anything could
+	// be stuck, or just have nothing to do, so we only count it as
+	// stalled if it also has changes pending.
+	replicationStalled = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_replication_stalled",
+		Help: "Whether the continuous replication has had changes pending but written no documents for a while (1) or not (0)",
+	},
+		[]string{"database", "docid"},
 	)
 
 	// Everything else is a counter-type, even if it's reset to zero somehow,
 	// at least if we are correctly labelling the metric.
-	docWriteFailuresTotal = utils.AutoNewSettableCounterVec(prometheus.Opts{
+	docWriteFailuresTotal = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
 		Name: "cloudant_replication_doc_write_failures_total",
 		Help: "The number of failures writing documents to the target",
 	},
-		[]string{"docid"},
-	)
-	docsReadTotal = utils.AutoNewSettableCounterVec(prometheus.Opts{
+		[]string{"database", "docid"},
+	))
+	docsReadTotal = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
 		Name: "cloudant_replication_docs_read_total",
 		Help: "Total number of documents read from the source database",
 	},
-		[]string{"docid"},
-	)
-	docsWrittenTotal = utils.AutoNewSettableCounterVec(prometheus.Opts{
+		[]string{"database", "docid"},
+	))
+	docsWrittenTotal = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
 		Name: "cloudant_replication_docs_written_total",
 		Help: "Total number of documents written to the target database",
 	},
-		[]string{"docid"},
-	)
-	missingRevsFoundTotal = utils.AutoNewSettableCounterVec(prometheus.Opts{
+		[]string{"database", "docid"},
+	))
+	missingRevsFoundTotal = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
 		Name: "cloudant_replication_missing_revs_found_total",
 		Help: "Total number of revs found so far on the source that are not at the target",
 	},
-		[]string{"docid"},
-	)
-	revsCheckedTotal = utils.AutoNewSettableCounterVec(prometheus.Opts{
+		[]string{"database", "docid"},
+	))
+	revsCheckedTotal = registry.Register(utils.NewSettableCounterVec(prometheus.Opts{
 		Name: "cloudant_replication_revs_checked_total",
 		Help: "Total number of revs processed on the source",
 	},
-		[]string{"docid"},
-	)
+		[]string{"database", "docid"},
+	))
 )
 
 func (rc *ReplicationProgressMonitor) Name() string {
@@ -62,25 +139,190 @@ func (rc *ReplicationProgressMonitor) Name() string {
 }
 
 func (rc *ReplicationProgressMonitor) Retrieve() error {
+	var batchSize int = 50
+	if rc.checkpoints == nil {
+		rc.checkpoints = map[string]checkpoint{}
+	}
+	if rc.written == nil {
+		rc.written = map[string]sample{}
+	}
+	if rc.progressed == nil {
+		rc.progressed = map[string]time.Time{}
+	}
+	if rc.continuous == nil {
+		rc.continuous = map[string]bool{}
+	}
+	// the replications running now
+	running := map[string]bool{}
+
 	// fetch scheduler status
 	getSchedulerDocsOptions := rc.Cldt.NewGetSchedulerDocsOptions()
-	getSchedulerDocsOptions.SetLimit(50)
+	getSchedulerDocsOptions.SetLimit(int64(batchSize))
 	getSchedulerDocsOptions.SetStates([]string{"running"})
 
-	schedulerDocsResult, _, err := rc.Cldt.GetSchedulerDocs(getSchedulerDocsOptions)
-	if err != nil {
-		return err
+	for _, replicatorDb := range replicatorDbsOrDefault(rc.ReplicatorDbs) {
+		var skip int = 0
+
+		// page through every running replication, so that each gets its
+		// own counters, until we get a smaller batch than we asked for
+		for {
+			getSchedulerDocsOptions.SetSkip(int64(skip))
+
+			schedulerDocsResult, err := schedulerDocs(rc.Cldt, replicatorDb, getSchedulerDocsOptions)
+			if err != nil {
+				return err
+			}
+			if err := rc.readContinuous(schedulerDocsResult.Docs); err != nil {
+				return err
+			}
+			for _, d := range schedulerDocsResult.Docs {
+				db, docID := *d.Database, *d.DocID
+				key := utils.LabelsKey(db, docID)
+				running[key] = true
+				logger(rc).Debug("Read replication progress", "database", db, "docid", docID, "docs_written", *d.Info.DocsWritten)
+				if d.Info.ChangesPending != nil {
+					changesPendingTotal.WithLabelValues(db, docID).Set(float64(*d.Info.ChangesPending))
+				}
+				docsPendingTotal.WithLabelValues(db, docID).Set(float64(docsPending(d.Info)))
+				if d.Source != nil && d.Target != nil {
+					replicationInfo.WithLabelValues(db, docID, redactURL(*d.Source), redactURL(*d.Target)).Set(1)
+				}
+				if d.Info.CheckpointedSourceSeq != nil {
+					secondsSinceLastCheckpoint.WithLabelValues(db, docID).Set(rc.checkpointAge(key, *d.Info.CheckpointedSourceSeq).Seconds())
+				}
+				rate, ok := rc.writeRate(key, *d.Info.DocsWritten)
+				if ok {
+					docsPerSecond.WithLabelValues(db, docID).Set(rate)
+				}
+				// a one-shot replication stops once it's caught up, so only
+				// a continuous one can sit running with nothing written
+				pending := d.Info.ChangesPending != nil && *d.Info.ChangesPending > 0
+				stalled := rc.stalled(key, !ok || rate > 0 || !pending)
+				replicationStalled.WithLabelValues(db, docID).Set(boolToFloat(rc.continuous[key] && stalled))
+				docWriteFailuresTotal.WithLabelValues(db, docID).Set(float64(*d.Info.DocWriteFailures))
+				docsReadTotal.WithLabelValues(db, docID).Set(float64(*d.Info.DocsRead))
+				docsWrittenTotal.WithLabelValues(db, docID).Set(float64(*d.Info.DocsWritten))
+				missingRevsFoundTotal.WithLabelValues(db, docID).Set(float64(*d.Info.MissingRevisionsFound))
+				revsCheckedTotal.WithLabelValues(db, docID).Set(float64(*d.Info.RevisionsChecked))
+			}
+			skip += len(schedulerDocsResult.Docs)
+			if len(schedulerDocsResult.Docs) < batchSize {
+				break
+			}
+		}
+	}
+
+	// forget replications which have stopped, in case their documents
+	// are recreated with a different setting, and drop their series
+	for key := range rc.continuous {
+		if !running[key] {
+			delete(rc.continuous, key)
+		}
+	}
+	for key := range rc.checkpoints {
+		if !running[key] {
+			delete(rc.checkpoints, key)
+		}
+	}
+	for key := range rc.written {
+		if !running[key] {
+			delete(rc.written, key)
+		}
+	}
+	for key := range rc.progressed {
+		if !running[key] {
+			delete(rc.progressed, key)
+		}
+	}
+	keep := utils.KeepLabels([]string{"database", "docid"}, running)
+	for _, vec := range []*prometheus.MetricVec{
+		changesPendingTotal.MetricVec,
+		docsPendingTotal.MetricVec,
+		secondsSinceLastCheckpoint.MetricVec,
+		docsPerSecond.MetricVec,
+		replicationInfo.MetricVec,
+		replicationStalled.MetricVec,
+		docWriteFailuresTotal.MetricVec,
+		docsReadTotal.MetricVec,
+		docsWrittenTotal.MetricVec,
+		missingRevsFoundTotal.MetricVec,
+		revsCheckedTotal.MetricVec,
+	} {
+		utils.DeleteStale(vec, keep)
+	}
+	return nil
+}
+
+// readContinuous records whether each of the replications docs is
+// continuous, reading the documents of those we haven't seen before.
+func (rc *ReplicationProgressMonitor) readContinuous(docs []cloudantv1.SchedulerDocument) error {
+	// doc IDs, keyed by replicator database
+	unknown := map[string][]string{}
+	for _, d := range docs {
+		if _, ok := rc.continuous[utils.LabelsKey(*d.Database, *d.DocID)]; !ok {
+			unknown[*d.Database] = append(unknown[*d.Database], *d.DocID)
+		}
 	}
-	for _, d := range schedulerDocsResult.Docs {
-		log.Printf("[ReplicationProgressMonitor] Replication %q: docs written %d", *d.DocID, *d.Info.DocsWritten)
-		if d.Info.ChangesPending != nil {
-			changesPendingTotal.WithLabelValues(*d.DocID).Set(float64(*d.Info.ChangesPending))
+	for db, docIDs := range unknown {
+		settings, err := readReplicationSettings(rc.Cldt, db, docIDs)
+		if err != nil {
+			return err
+		}
+		for _, docID := range docIDs {
+			rc.continuous[utils.LabelsKey(db, docID)] = settings[docID].continuous
 		}
-		docWriteFailuresTotal.WithLabelValues(*d.DocID).Set(float64(*d.Info.DocWriteFailures))
-		docsReadTotal.WithLabelValues(*d.DocID).Set(float64(*d.Info.DocsRead))
-		docsWrittenTotal.WithLabelValues(*d.DocID).Set(float64(*d.Info.DocsWritten))
-		missingRevsFoundTotal.WithLabelValues(*d.DocID).Set(float64(*d.Info.MissingRevisionsFound))
-		revsCheckedTotal.WithLabelValues(*d.DocID).Set(float64(*d.Info.RevisionsChecked))
 	}
 	return nil
 }
+
+// checkpointAge records seq as the latest checkpoint for the replication
+// key, and returns how long that checkpoint has been in place.
+func (rc *ReplicationProgressMonitor) checkpointAge(key, seq string) time.Duration {
+	cp, ok := rc.checkpoints[key]
+	if !ok || cp.seq != seq {
+		cp = checkpoint{seq: seq, seen: time.Now()}
+		rc.checkpoints[key] = cp
+	}
+	return time.Since(cp.seen)
+}
+
+// writeRate records docsWritten for the replication key, and returns
+// the docs per second written since the previous sample. There's no rate
+// for the first sample, and the rate is zero if the replication restarted.
+func (rc *ReplicationProgressMonitor) writeRate(key string, docsWritten int64) (float64, bool) {
+	now := time.Now()
+	prev, ok := rc.written[key]
+	rc.written[key] = sample{value: docsWritten, at: now}
+	if !ok {
+		return 0, false
+	}
+	elapsed := now.Sub(prev.at).Seconds()
+	if elapsed <= 0 {
+		return 0, false
+	}
+	if docsWritten < prev.value {
+		return 0, true
+	}
+	return float64(docsWritten-prev.value) / elapsed, true
+}
+
+// stalled records whether the replication key made progress in the
+// latest poll, and returns whether it has gone StallAfter without any.
+func (rc *ReplicationProgressMonitor) stalled(key string, progress bool) bool {
+	last, ok := rc.progressed[key]
+	if progress || !ok {
+		rc.progressed[key] = time.Now()
+		return false
+	}
+	return time.Since(last) >= rc.StallAfter
+}
+
+// docsPending estimates the replication's write backlog from the
+// revisions it has found missing on the target.
+func docsPending(info *cloudantv1.SchedulerInfo) int64 {
+	pending := *info.MissingRevisionsFound - *info.DocsWritten - *info.DocWriteFailures
+	if pending < 0 {
+		return 0
+	}
+	return pending
+}
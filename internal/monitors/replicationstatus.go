@@ -1,36 +1,105 @@
 package monitors
 
 import (
-	"log"
+	"strconv"
 	"time"
 
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"cloudant.com/cloudant_exporter/internal/utils"
 	"github.com/IBM/cloudant-go-sdk/cloudantv1"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 type ReplicationStatusMonitor struct {
 	Cldt *cloudantv1.CloudantV1
+
+	// ReplicatorDbs limits the monitor to these replicator databases,
+	// rather than all of them.
+	ReplicatorDbs []string
 }
 
 var (
-	replicatonStatus = promauto.NewGaugeVec(
+	replicatonStatus = registry.Auto.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "cloudant_replication_status_count",
 			Help: "Current replication count by status",
 		},
 		[]string{"status"},
 	)
+	replicatorDocs = registry.Auto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cloudant_replicator_docs",
+			Help: "Current replication document count by replicator database and state",
+		},
+		[]string{"database", "state"},
+	)
+	replicationLastErrorInfo = registry.Auto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cloudant_replication_last_error_info",
+			Help: "The latest error reported for the replication, truncated; always 1",
+		},
+		[]string{"database", "docid", "reason"},
+	)
+	// The edges of the account's replication graph, for eg a
+	// Grafana node graph panel.
+	replicationTopology = registry.Auto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cloudant_replication_topology",
+			Help: "The hosts the replication copies data between; always 1",
+		},
+		[]string{"source_host", "target_host", "database", "docid"},
+	)
+	// A low-cardinality summary of the topology, for accounts
+	// with many replications.
+	replicationsByRoute = registry.Auto.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Name: "cloudant_replications_by_route",
+			Help: "Current replication count by source and target host",
+		},
+		[]string{"source_host", "target_host"},
+	)
+)
+
+// Whether a replication is continuous is only recorded in its
+// replication document, so we read those in batches.
+var replicationsTotal = registry.Auto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "cloudant_replications_total",
+		Help: "Current replication count by whether it is continuous, and state",
+	},
+	[]string{"continuous", "state"},
 )
 
+// The settings are taken from the replication document, so are "default"
+// when the document doesn't set them.
+var replicationSettingsInfo = registry.Auto.NewGaugeVec(
+	prometheus.GaugeOpts{
+		Name: "cloudant_replication_settings_info",
+		Help: "The replication's worker processes, HTTP connections and worker batch size; always 1",
+	},
+	[]string{"database", "docid", "worker_processes", "http_connections", "worker_batch_size"},
+)
+
+// replicationSettings is what we read from a replication document.
+type replicationSettings struct {
+	continuous      bool
+	workerProcesses string
+	httpConnections string
+	workerBatchSize string
+}
+
+// replicationDocsBatchSize is how many replication documents we fetch at once.
+const replicationDocsBatchSize = 100
+
+// maxReasonLength limits the length of error reason labels.
+const maxReasonLength = 100
+
 func (rc *ReplicationStatusMonitor) Name() string {
 	return "ReplicationStatusMonitor"
 }
 
 func (rc *ReplicationStatusMonitor) Retrieve() error {
-	var skip int = 0
 	var batchSize int = 100
-	var iterations = 0
 	getSchedulerDocsOptions := rc.Cldt.NewGetSchedulerDocsOptions()
 	getSchedulerDocsOptions.SetLimit(int64(batchSize))
 	statusCounts := map[string]uint{
@@ -42,33 +111,206 @@ func (rc *ReplicationStatusMonitor) Retrieve() error {
 		"completed":    0,
 		"failed":       0,
 	}
+	// per-replicator database counts, keyed by database then state
+	dbStateCounts := map[string]map[string]uint{}
+	// error reasons and source and target hosts, keyed by replicator
+	// database then doc ID, as doc IDs are only unique within one
+	errorReasons := map[string]map[string]string{}
+	topology := map[string]map[string][2]string{}
+	// doc states, keyed by replicator database then doc ID
+	docStates := map[string]map[string]string{}
+
+	for _, replicatorDb := range replicatorDbsOrDefault(rc.ReplicatorDbs) {
+		var skip int = 0
+		var iterations = 0
+
+		// repeat until we get a smaller batch than we asked for
+		for {
+			// fetch scheduler jobs
+			getSchedulerDocsOptions.SetSkip(int64(skip))
 
-	// repeat until we get a smaller batch than we asked for
-	for {
-		// fetch scheduler jobs
-		getSchedulerDocsOptions.SetSkip(int64(skip))
+			schedulerJobsResult, err := schedulerDocs(rc.Cldt, replicatorDb, getSchedulerDocsOptions)
+			if err != nil {
+				return err
+			}
+			for _, d := range schedulerJobsResult.Docs {
+				statusCounts[*d.State]++
+				if _, ok := dbStateCounts[*d.Database]; !ok {
+					dbStateCounts[*d.Database] = map[string]uint{
+						"completed": 0,
+						"failed":    0,
+						"crashing":  0,
+						"running":   0,
+						"pending":   0,
+					}
+				}
+				dbStateCounts[*d.Database][*d.State]++
+				if _, ok := docStates[*d.Database]; !ok {
+					docStates[*d.Database] = map[string]string{}
+				}
+				docStates[*d.Database][*d.DocID] = *d.State
+				if d.Source != nil && d.Target != nil {
+					if _, ok := topology[*d.Database]; !ok {
+						topology[*d.Database] = map[string][2]string{}
+					}
+					topology[*d.Database][*d.DocID] = [2]string{urlHost(*d.Source), urlHost(*d.Target)}
+				}
+				if d.Info != nil && d.Info.Error != nil {
+					if _, ok := errorReasons[*d.Database]; !ok {
+						errorReasons[*d.Database] = map[string]string{}
+					}
+					errorReasons[*d.Database][*d.DocID] = truncate(*d.Info.Error, maxReasonLength)
+				}
+			}
+			skip += len(schedulerJobsResult.Docs)
+			iterations++
+			if len(schedulerJobsResult.Docs) < batchSize || iterations == 10 {
+				break
+			} else if err := sleep(5 * time.Second); err != nil {
+				return err
+			}
+		}
+	}
+
+	// output one metric per replication status
+	for key, val := range statusCounts {
+		logger(rc).Debug("Counted replications", "status", key, "replications", val)
+		replicatonStatus.WithLabelValues(key).Set(float64(val))
+	}
+	replicatorDbs := map[string]bool{}
+	for db, counts := range dbStateCounts {
+		for key, val := range counts {
+			replicatorDocs.WithLabelValues(db, key).Set(float64(val))
+		}
+		replicatorDbs[db] = true
+	}
+	// replicator databases which have been deleted or emptied
+	utils.DeleteStale(replicatorDocs.MetricVec, utils.KeepLabel("database", replicatorDbs))
 
-		schedulerJobsResult, _, err := rc.Cldt.GetSchedulerDocs(getSchedulerDocsOptions)
+	continuousCounts := map[bool]map[string]uint{}
+	for _, continuous := range []bool{true, false} {
+		continuousCounts[continuous] = map[string]uint{}
+		for state := range statusCounts {
+			continuousCounts[continuous][state] = 0
+		}
+	}
+	// read every replicator database's settings before changing any
+	// of the metrics, so a failure part way through leaves them as
+	// they were
+	settingsLabels := [][]string{}
+	for db, states := range docStates {
+		docIDs := make([]string, 0, len(states))
+		for docID := range states {
+			docIDs = append(docIDs, docID)
+		}
+		settings, err := readReplicationSettings(rc.Cldt, db, docIDs)
 		if err != nil {
 			return err
 		}
-		for _, d := range schedulerJobsResult.Docs {
-			statusCounts[*d.State]++
+		for docID, state := range states {
+			s, ok := settings[docID]
+			continuousCounts[ok && s.continuous][state]++
+			if ok {
+				settingsLabels = append(settingsLabels, []string{db, docID, s.workerProcesses, s.httpConnections, s.workerBatchSize})
+			}
 		}
-		skip += len(schedulerJobsResult.Docs)
-		iterations++
-		if len(schedulerJobsResult.Docs) < batchSize || iterations == 10 {
-			break
-		} else {
-			time.Sleep(5 * time.Second)
+	}
+	// remove the settings of deleted or changed replications
+	current := map[string]bool{}
+	for _, lvs := range settingsLabels {
+		replicationSettingsInfo.WithLabelValues(lvs...).Set(1)
+		current[utils.LabelsKey(lvs...)] = true
+	}
+	utils.DeleteStale(replicationSettingsInfo.MetricVec, utils.KeepLabels([]string{"database", "docid", "worker_processes", "http_connections", "worker_batch_size"}, current))
+	for continuous, counts := range continuousCounts {
+		for state, val := range counts {
+			replicationsTotal.WithLabelValues(strconv.FormatBool(continuous), state).Set(float64(val))
 		}
 	}
 
-	// output one metric per replication status
-	for key, val := range statusCounts {
-		log.Printf("[ReplicationProgressMonitor] %s %d", key, val)
-		replicatonStatus.WithLabelValues(key).Set(float64(val))
+	routes := map[string]bool{}
+	routeCounts := map[[2]string]uint{}
+	for db, docs := range topology {
+		for docID, hosts := range docs {
+			replicationTopology.WithLabelValues(hosts[0], hosts[1], db, docID).Set(1)
+			routes[utils.LabelsKey(hosts[0], hosts[1], db, docID)] = true
+			routeCounts[hosts]++
+		}
+	}
+	// replications which have been deleted or now copy between other hosts
+	utils.DeleteStale(replicationTopology.MetricVec, utils.KeepLabels([]string{"source_host", "target_host", "database", "docid"}, routes))
+	hostPairs := map[string]bool{}
+	for hosts, val := range routeCounts {
+		replicationsByRoute.WithLabelValues(hosts[0], hosts[1]).Set(float64(val))
+		hostPairs[utils.LabelsKey(hosts[0], hosts[1])] = true
+	}
+	utils.DeleteStale(replicationsByRoute.MetricVec, utils.KeepLabels([]string{"source_host", "target_host"}, hostPairs))
+
+	errored := map[string]bool{}
+	for db, reasons := range errorReasons {
+		for docID, reason := range reasons {
+			replicationLastErrorInfo.WithLabelValues(db, docID, reason).Set(1)
+			errored[utils.LabelsKey(db, docID, reason)] = true
+		}
 	}
+	// errors which have cleared or been replaced by another
+	utils.DeleteStale(replicationLastErrorInfo.MetricVec, utils.KeepLabels([]string{"database", "docid", "reason"}, errored))
 
 	return nil
 }
+
+// readReplicationSettings reads the replication documents docIDs from
+// replicator database db, returning the settings of each by doc ID.
+func readReplicationSettings(cldt *cloudantv1.CloudantV1, db string, docIDs []string) (map[string]replicationSettings, error) {
+	settings := map[string]replicationSettings{}
+	for start := 0; start < len(docIDs); start += replicationDocsBatchSize {
+		end := start + replicationDocsBatchSize
+		if end > len(docIDs) {
+			end = len(docIDs)
+		}
+
+		postAllDocsOptions := cldt.NewPostAllDocsOptions(db)
+		postAllDocsOptions.SetKeys(docIDs[start:end])
+		postAllDocsOptions.SetIncludeDocs(true)
+		allDocsResult, _, err := cldt.PostAllDocs(postAllDocsOptions)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range allDocsResult.Rows {
+			if r.Doc == nil {
+				continue
+			}
+			c, _ := r.Doc.GetProperty("continuous").(bool)
+			settings[*r.Key] = replicationSettings{
+				continuous:      c,
+				workerProcesses: docSetting(r.Doc, "worker_processes"),
+				httpConnections: docSetting(r.Doc, "http_connections"),
+				workerBatchSize: docSetting(r.Doc, "worker_batch_size"),
+			}
+		}
+	}
+
+	return settings, nil
+}
+
+// docSetting returns the numeric property name of doc as a
+// label value, or "default" if doc doesn't set it.
+func docSetting(doc *cloudantv1.Document, name string) string {
+	switch v := doc.GetProperty(name).(type) {
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case string:
+		return v
+	default:
+		return "default"
+	}
+}
+
+// truncate shortens s to at most n runes.
+func truncate(s string, n int) string {
+	r := []rune(s)
+	if len(r) <= n {
+		return s
+	}
+	return string(r[:n])
+}
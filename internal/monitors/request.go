@@ -0,0 +1,126 @@
+package monitors
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	_ "cloudant.com/cloudant_exporter/internal/registry" // lists the metrics registered here
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/IBM/cloudant-go-sdk/common"
+	"github.com/IBM/go-sdk-core/v5/core"
+)
+
+// shutdownCtx is cancelled when the exporter shuts down, abandoning
+// the requests and pauses of the polls in progress.
+var shutdownCtx = context.Background()
+
+// SetContext sets the context whose cancellation abandons the polls
+// in progress. Call it before starting any monitors.
+func SetContext(ctx context.Context) {
+	shutdownCtx = ctx
+}
+
+// sleep pauses for d, returning the context's error if the exporter
+// shuts down first.
+func sleep(d time.Duration) error {
+	select {
+	case <-time.After(d):
+		return nil
+	case <-shutdownCtx.Done():
+		return shutdownCtx.Err()
+	}
+}
+
+// errNotFound marks the errors of 404 responses, eg for a database
+// deleted between listing the databases and requesting its details.
+var errNotFound = errors.New("not found")
+
+// wrapNotFound marks err as errNotFound if resp is a 404.
+func wrapNotFound(resp *core.DetailedResponse, err error) error {
+	if err != nil && resp != nil && resp.StatusCode == http.StatusNotFound {
+		return fmt.Errorf("%w: %v", errNotFound, err)
+	}
+	return err
+}
+
+// getJSON makes a GET request to path, for endpoints that the SDK doesn't
+// support, and decodes the JSON response into result. The operationID is
+// used for the SDK analytics header, as the generated SDK code does.
+func getJSON(cldt *cloudantv1.CloudantV1, operationID string, path string, pathParams map[string]string, query map[string]string, result interface{}) error {
+	return requestJSON(cldt, core.GET, operationID, path, pathParams, query, nil, result)
+}
+
+// postJSON makes a POST request to path with body encoded as JSON, for
+// when we need fields of the response that the SDK's models don't include.
+func postJSON(cldt *cloudantv1.CloudantV1, operationID string, path string, body interface{}, result interface{}) error {
+	return requestJSON(cldt, core.POST, operationID, path, nil, nil, body, result)
+}
+
+// getText makes a GET request to path for a non-JSON response, returning
+// the response body, which the caller must close.
+func getText(cldt *cloudantv1.CloudantV1, operationID string, path string, pathParams map[string]string) (io.ReadCloser, error) {
+	request, err := newRequest(cldt, core.GET, operationID, path, pathParams, nil, nil, "text/plain")
+	if err != nil {
+		return nil, err
+	}
+
+	var body io.ReadCloser
+	_, err = cldt.Service.Request(request, &body)
+	if err != nil {
+		return nil, err
+	}
+	return body, nil
+}
+
+func requestJSON(cldt *cloudantv1.CloudantV1, method string, operationID string, path string, pathParams map[string]string, query map[string]string, body interface{}, result interface{}) error {
+	request, err := newRequest(cldt, method, operationID, path, pathParams, query, body, "application/json")
+	if err != nil {
+		return err
+	}
+
+	var rawResponse json.RawMessage
+	resp, err := cldt.Service.Request(request, &rawResponse)
+	if err != nil {
+		return wrapNotFound(resp, err)
+	}
+	if rawResponse != nil {
+		return json.Unmarshal(rawResponse, result)
+	}
+	return nil
+}
+
+// newRequest builds a request in the same way as the generated SDK code.
+func newRequest(cldt *cloudantv1.CloudantV1, method string, operationID string, path string, pathParams map[string]string, query map[string]string, body interface{}, accept string) (*http.Request, error) {
+	builder := core.NewRequestBuilder(method)
+	builder = builder.WithContext(shutdownCtx)
+	builder.EnableGzipCompression = cldt.GetEnableGzipCompression()
+	_, err := builder.ResolveRequestURL(cldt.Service.Options.URL, path, pathParams)
+	if err != nil {
+		return nil, err
+	}
+
+	sdkHeaders := common.GetSdkHeaders("cloudant", "V1", operationID)
+	for headerName, headerValue := range sdkHeaders {
+		builder.AddHeader(headerName, headerValue)
+	}
+	builder.AddHeader("Accept", accept)
+
+	for k, v := range query {
+		builder.AddQuery(k, v)
+	}
+
+	if body != nil {
+		builder.AddHeader("Content-Type", "application/json")
+		_, err = builder.SetBodyContentJSON(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return builder.Build()
+}
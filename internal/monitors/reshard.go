@@ -0,0 +1,102 @@
+package monitors
+
+import (
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"cloudant.com/cloudant_exporter/internal/utils"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ReshardMonitor exports the state of shard splitting jobs from
+// /_reshard/jobs. This is only available on CouchDB 3.x and
+// Cloudant dedicated clusters.
+type ReshardMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+}
+
+var (
+	reshardJobs = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_reshard_jobs",
+		Help: "The number of resharding jobs, by state",
+	},
+		[]string{"state"},
+	)
+	reshardJobProgress = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_reshard_job_progress_ratio",
+		Help: "How far through its steps the resharding job is (0-1)",
+	},
+		[]string{"id", "node", "source"},
+	)
+)
+
+// reshardJobStates are the states a resharding job can be in.
+var reshardJobStates = []string{"new", "running", "stopped", "completed", "failed"}
+
+// reshardSplitStates are the steps of splitting a shard, in order.
+var reshardSplitStates = []string{
+	"new",
+	"initial_copy",
+	"topoff1",
+	"build_indices",
+	"topoff2",
+	"copy_local_docs",
+	"update_shardmap",
+	"wait_source_close",
+	"topoff3",
+	"source_delete",
+	"completed",
+}
+
+// reshardJobsResult is the response from /_reshard/jobs.
+type reshardJobsResult struct {
+	Jobs []struct {
+		ID         string `json:"id"`
+		Node       string `json:"node"`
+		Source     string `json:"source"`
+		JobState   string `json:"job_state"`
+		SplitState string `json:"split_state"`
+	} `json:"jobs"`
+}
+
+func (rm *ReshardMonitor) Name() string {
+	return "ReshardMonitor"
+}
+
+func (rm *ReshardMonitor) Retrieve() error {
+	jobs := &reshardJobsResult{}
+	err := getJSON(rm.Cldt, "GetReshardJobs", `/_reshard/jobs`, nil, nil, jobs)
+	if err != nil {
+		return err
+	}
+
+	stateCounts := map[string]uint{}
+	for _, s := range reshardJobStates {
+		stateCounts[s] = 0
+	}
+	seen := map[string]bool{}
+	for _, j := range jobs.Jobs {
+		stateCounts[j.JobState]++
+		reshardJobProgress.WithLabelValues(j.ID, j.Node, j.Source).Set(splitProgress(j.SplitState))
+		seen[utils.LabelsKey(j.ID, j.Node, j.Source)] = true
+	}
+	// jobs which have been removed
+	utils.DeleteStale(reshardJobProgress.MetricVec, utils.KeepLabels([]string{"id", "node", "source"}, seen))
+
+	logger(rm).Info("Read resharding jobs", "jobs", len(jobs.Jobs))
+	for state, n := range stateCounts {
+		reshardJobs.WithLabelValues(state).Set(float64(n))
+	}
+
+	return nil
+}
+
+// splitProgress returns the proportion of the split steps that
+// come before splitState.
+func splitProgress(splitState string) float64 {
+	for i, s := range reshardSplitStates {
+		if s == splitState {
+			return float64(i) / float64(len(reshardSplitStates)-1)
+		}
+	}
+	return 0
+}
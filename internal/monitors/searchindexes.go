@@ -0,0 +1,100 @@
+package monitors
+
+import (
+	"errors"
+	"strings"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"cloudant.com/cloudant_exporter/internal/utils"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type SearchIndexesMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+
+	// Filter chooses the databases to check.
+	Filter *DatabaseFilter
+}
+
+var (
+	searchIndexDiskSizeBytes = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_search_index_disk_size_bytes",
+		Help: "The size of the search index on disk, in bytes",
+	},
+		[]string{"database", "design_document", "index"},
+	)
+	searchIndexDocCount = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_search_index_doc_count",
+		Help: "The number of documents in the search index",
+	},
+		[]string{"database", "design_document", "index"},
+	)
+	searchIndexDocDelCount = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_search_index_doc_del_count",
+		Help: "The number of deleted documents in the search index",
+	},
+		[]string{"database", "design_document", "index"},
+	)
+)
+
+func (si *SearchIndexesMonitor) Name() string {
+	return "SearchIndexesMonitor"
+}
+
+func (si *SearchIndexesMonitor) Retrieve() error {
+	dbs, err := allDbs(si.Cldt, si.Filter)
+	if err != nil {
+		return err
+	}
+
+	// the database, design document or index may be deleted at any
+	// point while we work through them, so skip any that 404
+	indexes := map[string]bool{}
+	for _, db := range dbs {
+		ddocIDs, err := designDocIDs(si.Cldt, db)
+		if errors.Is(err, errNotFound) {
+			logger(si).Debug("Database deleted since listing it", "database", db)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		for _, ddocID := range ddocIDs {
+			ddocName := strings.TrimPrefix(ddocID, "_design/")
+			getDesignDocumentOptions := si.Cldt.NewGetDesignDocumentOptions(db, ddocName)
+			ddoc, resp, err := si.Cldt.GetDesignDocument(getDesignDocumentOptions)
+			err = wrapNotFound(resp, err)
+			if errors.Is(err, errNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			for index := range ddoc.Indexes {
+				getSearchInfoOptions := si.Cldt.NewGetSearchInfoOptions(db, ddocName, index)
+				searchInfoResult, resp, err := si.Cldt.GetSearchInfo(getSearchInfoOptions)
+				err = wrapNotFound(resp, err)
+				if errors.Is(err, errNotFound) {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+				searchIndexDiskSizeBytes.WithLabelValues(db, ddocID, index).Set(float64(*searchInfoResult.SearchIndex.DiskSize))
+				searchIndexDocCount.WithLabelValues(db, ddocID, index).Set(float64(*searchInfoResult.SearchIndex.DocCount))
+				searchIndexDocDelCount.WithLabelValues(db, ddocID, index).Set(float64(*searchInfoResult.SearchIndex.DocDelCount))
+				indexes[utils.LabelsKey(db, ddocID, index)] = true
+			}
+		}
+	}
+	keep := utils.KeepLabels([]string{"database", "design_document", "index"}, indexes)
+	utils.DeleteStale(searchIndexDiskSizeBytes.MetricVec, keep)
+	utils.DeleteStale(searchIndexDocCount.MetricVec, keep)
+	utils.DeleteStale(searchIndexDocDelCount.MetricVec, keep)
+	logger(si).Info("Retrieved search index info", "indexes", len(indexes))
+
+	return nil
+}
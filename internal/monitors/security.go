@@ -0,0 +1,102 @@
+package monitors
+
+import (
+	"errors"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"cloudant.com/cloudant_exporter/internal/utils"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type SecurityMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+
+	// Filter chooses the databases to check.
+	Filter *DatabaseFilter
+}
+
+var (
+	securityMembers = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_security_members",
+		Help: "The number of member names and roles in the database's security object",
+	},
+		[]string{"database"},
+	)
+	securityAdmins = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_security_admins",
+		Help: "The number of admin names and roles in the database's security object",
+	},
+		[]string{"database"},
+	)
+	securityAPIKeys = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_security_api_keys",
+		Help: "The number of users and API keys given Cloudant permissions in the database's security object",
+	},
+		[]string{"database"},
+	)
+	// With CouchDB semantics, a database with no members can be
+	// read by anyone.
+	securityEmpty = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_database_security_empty",
+		Help: "Whether the database's security object grants nothing to anyone (1) or not (0)",
+	},
+		[]string{"database"},
+	)
+)
+
+func (sm *SecurityMonitor) Name() string {
+	return "SecurityMonitor"
+}
+
+func (sm *SecurityMonitor) Retrieve() error {
+	dbs, err := allDbs(sm.Cldt, sm.Filter)
+	if err != nil {
+		return err
+	}
+
+	checked := map[string]bool{}
+	for _, db := range dbs {
+		getSecurityOptions := sm.Cldt.NewGetSecurityOptions(db)
+		securityResult, resp, err := sm.Cldt.GetSecurity(getSecurityOptions)
+		err = wrapNotFound(resp, err)
+		if errors.Is(err, errNotFound) {
+			logger(sm).Debug("Database deleted since listing it", "database", db)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		members := securityObjectSize(securityResult.Members)
+		admins := securityObjectSize(securityResult.Admins)
+		apiKeys := 0
+		for name := range securityResult.Cloudant {
+			// "nobody" is the unauthenticated user, not a key
+			if name != "nobody" {
+				apiKeys++
+			}
+		}
+		securityMembers.WithLabelValues(db).Set(float64(members))
+		securityAdmins.WithLabelValues(db).Set(float64(admins))
+		securityAPIKeys.WithLabelValues(db).Set(float64(apiKeys))
+		securityEmpty.WithLabelValues(db).Set(boolToFloat(members == 0 && admins == 0 && len(securityResult.Cloudant) == 0))
+		checked[db] = true
+	}
+	// databases which have been deleted or no longer pass the filter
+	keep := utils.KeepLabel("database", checked)
+	utils.DeleteStale(securityMembers.MetricVec, keep)
+	utils.DeleteStale(securityAdmins.MetricVec, keep)
+	utils.DeleteStale(securityAPIKeys.MetricVec, keep)
+	utils.DeleteStale(securityEmpty.MetricVec, keep)
+	logger(sm).Info("Checked security", "databases", len(checked))
+
+	return nil
+}
+
+func securityObjectSize(so *cloudantv1.SecurityObject) int {
+	if so == nil {
+		return 0
+	}
+	return len(so.Names) + len(so.Roles)
+}
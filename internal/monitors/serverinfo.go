@@ -0,0 +1,51 @@
+package monitors
+
+import (
+	"sort"
+	"strings"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type ServerInfoMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+}
+
+var (
+	// This is reset on every poll, so that an upgrade replaces the
+	// old version's series rather than leaving it behind.
+	serverInfo = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_server_info",
+		Help: "The server's version, vendor and enabled features; always 1",
+	},
+		[]string{"version", "vendor", "features"},
+	)
+)
+
+func (sm *ServerInfoMonitor) Name() string {
+	return "ServerInfoMonitor"
+}
+
+func (sm *ServerInfoMonitor) Retrieve() error {
+	getServerInformationOptions := sm.Cldt.NewGetServerInformationOptions()
+	serverResult, _, err := sm.Cldt.GetServerInformation(getServerInformationOptions)
+	if err != nil {
+		return err
+	}
+
+	vendor := ""
+	if serverResult.Vendor != nil && serverResult.Vendor.Name != nil {
+		vendor = *serverResult.Vendor.Name
+	}
+	// sort the features so the label doesn't change with their order
+	features := append([]string{}, serverResult.Features...)
+	sort.Strings(features)
+
+	logger(sm).Info("Read server info", "server_version", *serverResult.Version, "vendor", vendor)
+	serverInfo.Reset()
+	serverInfo.WithLabelValues(*serverResult.Version, vendor, strings.Join(features, ",")).Set(1)
+
+	return nil
+}
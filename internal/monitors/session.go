@@ -0,0 +1,54 @@
+package monitors
+
+import (
+	"net/http"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// SessionMonitor checks that the exporter's credentials are still
+// accepted. Rejected credentials are reported in the metric rather than
+// as an error, so they are visible before every other monitor fails.
+// Only IAM authentication exports the time left on its credentials,
+// as cloudant_iam_token_expiry_seconds; the SDK doesn't expose the
+// expiry of a session cookie.
+type SessionMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+}
+
+var (
+	authValid = registry.Auto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudant_auth_valid",
+		Help: "Whether the exporter's credentials are accepted (1) or not (0)",
+	})
+)
+
+func (sm *SessionMonitor) Name() string {
+	return "SessionMonitor"
+}
+
+func (sm *SessionMonitor) Retrieve() error {
+	getSessionInformationOptions := sm.Cldt.NewGetSessionInformationOptions()
+	sessionResult, response, err := sm.Cldt.GetSessionInformation(getSessionInformationOptions)
+	if err != nil {
+		if response != nil && (response.StatusCode == http.StatusUnauthorized || response.StatusCode == http.StatusForbidden) {
+			logger(sm).Warn("Credentials rejected", "err", err)
+			authValid.Set(0)
+			return nil
+		}
+		return err
+	}
+
+	// an unauthenticated session has a null user name
+	valid := sessionResult.UserCtx != nil && sessionResult.UserCtx.Name != nil
+	if valid {
+		logger(sm).Info("Authenticated", "user", *sessionResult.UserCtx.Name)
+	} else {
+		logger(sm).Warn("Not authenticated")
+	}
+	authValid.Set(boolToFloat(valid))
+
+	return nil
+}
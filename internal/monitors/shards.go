@@ -0,0 +1,97 @@
+package monitors
+
+import (
+	"errors"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"cloudant.com/cloudant_exporter/internal/utils"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type ShardsMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+
+	// Filter chooses the databases to check.
+	Filter *DatabaseFilter
+}
+
+var (
+	nodeShardTotal = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_node_shard_total",
+		Help: "The number of shard replicas hosted by the node",
+	},
+		[]string{"node"},
+	)
+	// Imbalance is the spread between the most and least loaded
+	// nodes, relative to the mean; 0 means perfectly balanced.
+	shardImbalance = registry.Auto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudant_shard_imbalance_ratio",
+		Help: "The difference between the largest and smallest per-node shard counts, divided by the mean",
+	})
+)
+
+func (sm *ShardsMonitor) Name() string {
+	return "ShardsMonitor"
+}
+
+func (sm *ShardsMonitor) Retrieve() error {
+	dbs, err := allDbs(sm.Cldt, sm.Filter)
+	if err != nil {
+		return err
+	}
+
+	shardCounts := map[string]uint{}
+	for _, db := range dbs {
+		getShardsInformationOptions := sm.Cldt.NewGetShardsInformationOptions(db)
+		shardsResult, resp, err := sm.Cldt.GetShardsInformation(getShardsInformationOptions)
+		err = wrapNotFound(resp, err)
+		if errors.Is(err, errNotFound) {
+			logger(sm).Debug("Database deleted since listing it", "database", db)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, nodes := range shardsResult.Shards {
+			for _, node := range nodes {
+				shardCounts[node]++
+			}
+		}
+	}
+
+	nodes := map[string]bool{}
+	for node, val := range shardCounts {
+		logger(sm).Debug("Counted shards", "node", node, "shards", val)
+		nodeShardTotal.WithLabelValues(node).Set(float64(val))
+		nodes[node] = true
+	}
+	// nodes which have left the cluster, or hold none of the shards
+	utils.DeleteStale(nodeShardTotal.MetricVec, utils.KeepLabel("node", nodes))
+	shardImbalance.Set(imbalance(shardCounts))
+
+	return nil
+}
+
+// imbalance returns (max - min) / mean of counts.
+func imbalance(counts map[string]uint) float64 {
+	if len(counts) == 0 {
+		return 0
+	}
+	var total, most uint
+	least := ^uint(0)
+	for _, c := range counts {
+		total += c
+		if c > most {
+			most = c
+		}
+		if c < least {
+			least = c
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	mean := float64(total) / float64(len(counts))
+	return float64(most-least) / mean
+}
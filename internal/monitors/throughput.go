@@ -1,28 +1,51 @@
 package monitors
 
 import (
-	"context"
 	"encoding/json"
 
+	"cloudant.com/cloudant_exporter/internal/registry"
 	"github.com/IBM/cloudant-go-sdk/cloudantv1"
 	"github.com/IBM/cloudant-go-sdk/common"
 	"github.com/IBM/go-sdk-core/v5/core"
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promauto"
 )
 
 type ThroughputMonitor struct {
 	Cldt *cloudantv1.CloudantV1
+
+	// last429Ts is the timestamp of the latest Deny429History
+	// record that we've counted.
+	last429Ts int64
+
+	// lastOperationTs is the timestamp of the latest
+	// OperationHistory record that we've counted.
+	lastOperationTs int64
 }
 
 var (
-	throughput = promauto.NewGaugeVec(
+	throughput = registry.Auto.NewGaugeVec(
 		prometheus.GaugeOpts{
 			Name: "cloudant_throughput_current_req_per_second",
 			Help: "Current requests per second per class",
 		},
 		[]string{"class", "ratelimited"},
 	)
+	rateLimitedTotal = registry.Auto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cloudant_throughput_ratelimited_requests_total",
+			Help: "Requests rejected with a 429 per class, since the exporter started",
+		},
+		[]string{"class"},
+	)
+	// The API only breaks requests down by class, not by HTTP method;
+	// on dedicated clusters, -node-stats exports requests by method.
+	requestsTotal = registry.Auto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "cloudant_throughput_requests_total",
+			Help: "Requests served per class, since the exporter started",
+		},
+		[]string{"class"},
+	)
 )
 
 func (tm *ThroughputMonitor) Name() string {
@@ -45,6 +68,38 @@ func (tm *ThroughputMonitor) Retrieve() error {
 	throughput.WithLabelValues("write", "true").Set(float64(latest.Write))
 	throughput.WithLabelValues("query", "true").Set(float64(latest.Query))
 
+	// Each history record is a per-second rate, so summing the records
+	// we've not seen before gives the number of requests rejected. On
+	// the first poll we just note where we're starting from.
+	if tm.last429Ts == 0 {
+		for _, class := range []string{"lookup", "write", "query"} {
+			rateLimitedTotal.WithLabelValues(class)
+		}
+	}
+	for _, r := range tr.Deny429History {
+		if tm.last429Ts != 0 && r.Ts > tm.last429Ts {
+			rateLimitedTotal.WithLabelValues("lookup").Add(float64(r.Lookup))
+			rateLimitedTotal.WithLabelValues("write").Add(float64(r.Write))
+			rateLimitedTotal.WithLabelValues("query").Add(float64(r.Query))
+		}
+	}
+	tm.last429Ts = latest.Ts
+
+	// OperationHistory is counted in the same way
+	if tm.lastOperationTs == 0 {
+		for _, class := range []string{"lookup", "write", "query"} {
+			requestsTotal.WithLabelValues(class)
+		}
+	}
+	for _, r := range tr.OperationHistory {
+		if tm.lastOperationTs != 0 && r.Ts > tm.lastOperationTs {
+			requestsTotal.WithLabelValues("lookup").Add(float64(r.Lookup))
+			requestsTotal.WithLabelValues("write").Add(float64(r.Write))
+			requestsTotal.WithLabelValues("query").Add(float64(r.Query))
+		}
+	}
+	tm.lastOperationTs = tr.OperationHistory[len(tr.OperationHistory)-1].Ts
+
 	return nil
 }
 
@@ -67,7 +122,7 @@ type ThroughputResponse struct {
 
 func (tm *ThroughputMonitor) ccmDiagnostics() (*ThroughputResponse, error) {
 	builder := core.NewRequestBuilder(core.GET)
-	builder = builder.WithContext(context.Background())
+	builder = builder.WithContext(shutdownCtx)
 	builder.EnableGzipCompression = tm.Cldt.GetEnableGzipCompression()
 	_, err := builder.ResolveRequestURL(tm.Cldt.Service.Options.URL, `/_api/v2/user/ccm_diagnostics`, nil)
 	if err != nil {
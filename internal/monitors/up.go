@@ -0,0 +1,67 @@
+package monitors
+
+import (
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type UpMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+
+	// PerNode also checks /_node/{node}/_up for every cluster node,
+	// which is only available on CouchDB 3.x and dedicated clusters.
+	PerNode bool
+}
+
+var (
+	up = registry.Auto.NewGauge(prometheus.GaugeOpts{
+		Name: "cloudant_up",
+		Help: "Whether the service reports itself as up (1) or not (0)",
+	})
+	nodeUp = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_node_up",
+		Help: "Whether the node reports itself as up (1) or not (0)",
+	},
+		[]string{"node"},
+	)
+)
+
+// upInformation is the response from /_node/{node}/_up.
+type upInformation struct {
+	Status string `json:"status"`
+}
+
+func (um *UpMonitor) Name() string {
+	return "UpMonitor"
+}
+
+func (um *UpMonitor) Retrieve() error {
+	getUpInformationOptions := um.Cldt.NewGetUpInformationOptions()
+	upResult, _, err := um.Cldt.GetUpInformation(getUpInformationOptions)
+	if err != nil {
+		up.Set(0)
+		return err
+	}
+	up.Set(boolToFloat(*upResult.Status == "ok"))
+
+	if !um.PerNode {
+		return nil
+	}
+
+	nodes, err := clusterNodes(um.Cldt)
+	if err != nil {
+		return err
+	}
+	for _, node := range nodes {
+		// a node being down isn't a failure of this monitor
+		nodeUpResult := &upInformation{}
+		err := getJSON(um.Cldt, "GetNodeUpInformation", `/_node/{node}/_up`, map[string]string{"node": node}, nil, nodeUpResult)
+		if err != nil {
+			logger(um).Warn("Node is not up", "node", node, "err", err)
+		}
+		nodeUp.WithLabelValues(node).Set(boolToFloat(err == nil && nodeUpResult.Status == "ok"))
+	}
+
+	return nil
+}
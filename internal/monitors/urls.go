@@ -0,0 +1,28 @@
+package monitors
+
+import (
+	"net/url"
+	"strings"
+)
+
+// redactURL reduces a replication source or target URL to its scheme,
+// host and database, dropping any credentials, query or fragment so
+// they can't leak into metric labels.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		// not a URL we understand, so don't risk exporting it
+		return ""
+	}
+	return u.Scheme + "://" + u.Host + "/" + strings.Trim(u.EscapedPath(), "/")
+}
+
+// urlHost returns the host of a replication source or target URL,
+// without any port, or "" if it isn't a URL.
+func urlHost(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
@@ -0,0 +1,175 @@
+package monitors
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"cloudant.com/cloudant_exporter/internal/utils"
+	"github.com/IBM/cloudant-go-sdk/cloudantv1"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+type ViewIndexesMonitor struct {
+	Cldt *cloudantv1.CloudantV1
+
+	// Filter chooses the databases to check.
+	Filter *DatabaseFilter
+}
+
+var (
+	viewIndexLagChanges = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_view_index_lag_changes",
+		Help: "The number of database changes not yet reflected in the design document's view index (approximately)",
+	},
+		[]string{"database", "design_document"},
+	)
+	viewPendingUpdates = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_view_pending_updates",
+		Help: "The number of changes the database's view indexes still have to process, summed across design documents (approximately)",
+	},
+		[]string{"database"},
+	)
+	viewIndexFileSizeBytes = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_view_index_file_size_bytes",
+		Help: "The size of the design document's view index files on disk, in bytes",
+	},
+		[]string{"database", "design_document"},
+	)
+	viewIndexActiveSizeBytes = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_view_index_active_size_bytes",
+		Help: "The size of live data inside the design document's view index, in bytes",
+	},
+		[]string{"database", "design_document"},
+	)
+	viewIndexCompactRunning = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_view_index_compact_running",
+		Help: "Whether the design document's view index is being compacted (1) or not (0)",
+	},
+		[]string{"database", "design_document"},
+	)
+	viewIndexUpdaterRunning = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_view_index_updater_running",
+		Help: "Whether the design document's view index is being updated (1) or not (0)",
+	},
+		[]string{"database", "design_document"},
+	)
+	viewIndexWaitingClients = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_view_index_waiting_clients",
+		Help: "The number of clients waiting for the design document's view index to update",
+	},
+		[]string{"database", "design_document"},
+	)
+)
+
+// designDocInfo is the subset of GET /{db}/_design/{ddoc}/_info
+// that we use. The SDK's model doesn't include the update_seq.
+type designDocInfo struct {
+	ViewIndex struct {
+		UpdateSeq      json.RawMessage `json:"update_seq"`
+		CompactRunning bool            `json:"compact_running"`
+		UpdaterRunning bool            `json:"updater_running"`
+		WaitingClients float64         `json:"waiting_clients"`
+		Sizes          struct {
+			Active float64 `json:"active"`
+			File   float64 `json:"file"`
+		} `json:"sizes"`
+	} `json:"view_index"`
+}
+
+func (vi *ViewIndexesMonitor) Name() string {
+	return "ViewIndexesMonitor"
+}
+
+func (vi *ViewIndexesMonitor) Retrieve() error {
+	dbs, err := allDbs(vi.Cldt, vi.Filter)
+	if err != nil {
+		return err
+	}
+
+	// the database or design document may be deleted at any point
+	// while we work through them, so skip any that 404
+	checkedDbs := map[string]bool{}
+	checkedDdocs := map[string]bool{}
+	for _, db := range dbs {
+		ddocIDs, err := designDocIDs(vi.Cldt, db)
+		if errors.Is(err, errNotFound) {
+			logger(vi).Debug("Database deleted since listing it", "database", db)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if len(ddocIDs) == 0 {
+			continue
+		}
+
+		getDatabaseInformationOptions := vi.Cldt.NewGetDatabaseInformationOptions(db)
+		dbInfo, resp, err := vi.Cldt.GetDatabaseInformation(getDatabaseInformationOptions)
+		err = wrapNotFound(resp, err)
+		if errors.Is(err, errNotFound) {
+			logger(vi).Debug("Database deleted since listing it", "database", db)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		dbSeq, ok := seqNumber(*dbInfo.UpdateSeq)
+		if !ok {
+			continue
+		}
+
+		var pending int64
+		for _, ddocID := range ddocIDs {
+			info := &designDocInfo{}
+			pathParams := map[string]string{"db": db, "ddoc": strings.TrimPrefix(ddocID, "_design/")}
+			err := getJSON(vi.Cldt, "GetDesignDocumentInformation", `/{db}/_design/{ddoc}/_info`, pathParams, nil, info)
+			if errors.Is(err, errNotFound) {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+
+			if viewSeq, ok := rawSeqNumber(info.ViewIndex.UpdateSeq); ok {
+				lag := dbSeq - viewSeq
+				if lag < 0 {
+					lag = 0
+				}
+				viewIndexLagChanges.WithLabelValues(db, ddocID).Set(float64(lag))
+				pending += lag
+			}
+			viewIndexFileSizeBytes.WithLabelValues(db, ddocID).Set(info.ViewIndex.Sizes.File)
+			viewIndexActiveSizeBytes.WithLabelValues(db, ddocID).Set(info.ViewIndex.Sizes.Active)
+			viewIndexCompactRunning.WithLabelValues(db, ddocID).Set(boolToFloat(info.ViewIndex.CompactRunning))
+			viewIndexUpdaterRunning.WithLabelValues(db, ddocID).Set(boolToFloat(info.ViewIndex.UpdaterRunning))
+			viewIndexWaitingClients.WithLabelValues(db, ddocID).Set(info.ViewIndex.WaitingClients)
+			checkedDdocs[utils.LabelsKey(db, ddocID)] = true
+		}
+		viewPendingUpdates.WithLabelValues(db).Set(float64(pending))
+		checkedDbs[db] = true
+	}
+	utils.DeleteStale(viewPendingUpdates.MetricVec, utils.KeepLabel("database", checkedDbs))
+	keep := utils.KeepLabels([]string{"database", "design_document"}, checkedDdocs)
+	for _, vec := range []*prometheus.GaugeVec{viewIndexLagChanges, viewIndexFileSizeBytes, viewIndexActiveSizeBytes, viewIndexCompactRunning, viewIndexUpdaterRunning, viewIndexWaitingClients} {
+		utils.DeleteStale(vec.MetricVec, keep)
+	}
+	logger(vi).Info("Checked view indexes", "databases", len(dbs))
+
+	return nil
+}
+
+// rawSeqNumber returns the numeric part of a sequence which may be
+// either a JSON number (single node CouchDB) or a clustered sequence string.
+func rawSeqNumber(raw json.RawMessage) (int64, bool) {
+	var n int64
+	if err := json.Unmarshal(raw, &n); err == nil {
+		return n, true
+	}
+	var s string
+	if err := json.Unmarshal(raw, &s); err != nil {
+		return 0, false
+	}
+	return seqNumber(s)
+}
@@ -0,0 +1,99 @@
+package ratelimit
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Transport wraps an http.RoundTripper to export the rate limiting
+// that the exporter's own requests to Cloudant run into, from the
+// responses' status codes and headers.
+type Transport struct {
+	Next http.RoundTripper
+}
+
+var (
+	rateLimitedResponses = registry.Auto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloudant_exporter_ratelimited_responses_total",
+		Help: "The number of the exporter's requests rejected with a 429, by class",
+	},
+		[]string{"class"},
+	)
+	retryAfterSeconds = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_exporter_retry_after_seconds",
+		Help: "The Retry-After of the latest rate limited response to the exporter, by class",
+	},
+		[]string{"class"},
+	)
+	rateLimitRemaining = registry.Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "cloudant_exporter_ratelimit_remaining",
+		Help: "The remaining request quota reported in the latest X-RateLimit-Remaining header, by class",
+	},
+		[]string{"class"},
+	)
+)
+
+// NewTransport wraps next, which is http.DefaultTransport if nil.
+func NewTransport(next http.RoundTripper) *Transport {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Transport{Next: next}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.Next.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	class := requestClass(req)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		rateLimitedResponses.WithLabelValues(class).Inc()
+		if d, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			retryAfterSeconds.WithLabelValues(class).Set(d.Seconds())
+		}
+	}
+	if remaining, err := strconv.ParseFloat(resp.Header.Get("X-RateLimit-Remaining"), 64); err == nil {
+		rateLimitRemaining.WithLabelValues(class).Set(remaining)
+	}
+
+	return resp, nil
+}
+
+// requestClass returns the Cloudant throughput class that req counts
+// against: query for views, search and Cloudant Query, lookup for
+// other reads, and write for everything else.
+func requestClass(req *http.Request) string {
+	path := req.URL.Path
+	for _, q := range []string{"/_view/", "/_search/", "/_find", "/_all_docs", "/_changes"} {
+		if strings.Contains(path, q) {
+			return "query"
+		}
+	}
+	if req.Method == http.MethodGet || req.Method == http.MethodHead {
+		return "lookup"
+	}
+	return "write"
+}
+
+// retryAfter parses a Retry-After header, which is
+// either a number of seconds or an HTTP date.
+func retryAfter(h string) (time.Duration, bool) {
+	if h == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(h); err == nil {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(h); err == nil {
+		return time.Until(t), true
+	}
+	return 0, false
+}
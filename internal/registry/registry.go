@@ -0,0 +1,223 @@
+// Package registry lists every metric the exporter may export,
+// including those without any series yet, from the descriptions of
+// the metrics registered with Prometheus' default registry. Packages
+// create their metrics with Auto, or register them with Register, so
+// that the types of their metrics are known before they have series.
+package registry
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// maxLabels bounds the number of variable labels we look for in a
+// metric's description.
+const maxLabels = 32
+
+var (
+	mu sync.Mutex
+	// types holds the type of each recorded metric family, by name.
+	types = map[string]string{}
+)
+
+// Registerer registers collectors with the default registerer,
+// recording the types of their metrics.
+var Registerer prometheus.Registerer = &recorder{Registerer: prometheus.DefaultRegisterer}
+
+// Auto creates metrics registered with Registerer, as promauto does.
+var Auto = promauto.With(Registerer)
+
+// Register registers c with Registerer, panicking if it can't, and
+// returns it, eg for custom collectors.
+func Register[C prometheus.Collector](c C) C {
+	Registerer.MustRegister(c)
+	return c
+}
+
+// recorder is a prometheus.Registerer recording the types of the
+// metrics registered with the Registerer it wraps.
+type recorder struct {
+	prometheus.Registerer
+}
+
+func (r *recorder) Register(c prometheus.Collector) error {
+	if err := r.Registerer.Register(c); err != nil {
+		return err
+	}
+	t := collectorType(c)
+	mu.Lock()
+	defer mu.Unlock()
+	for _, d := range describe(c) {
+		if f, ok := family(d); ok {
+			types[f.Name] = t
+		}
+	}
+	return nil
+}
+
+func (r *recorder) MustRegister(cs ...prometheus.Collector) {
+	for _, c := range cs {
+		if err := r.Register(c); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// Family describes a registered metric family.
+type Family struct {
+	Name   string
+	Type   string
+	Help   string
+	Labels []string
+}
+
+// Families returns the metric families described by the default
+// registry, sorted by name. The types of those not registered through
+// Registerer, eg the Go runtime's, come from their current series.
+func Families() []Family {
+	gathered := map[string]string{}
+	if mfs, err := prometheus.DefaultGatherer.Gather(); err == nil {
+		for _, mf := range mfs {
+			gathered[mf.GetName()] = typeName(mf.GetType())
+		}
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	families := []Family{}
+	for _, d := range describe(prometheus.DefaultRegisterer.(prometheus.Collector)) {
+		f, ok := family(d)
+		if !ok {
+			continue
+		}
+		f.Type = "untyped"
+		if t, ok := types[f.Name]; ok {
+			f.Type = t
+		} else if t, ok := gathered[f.Name]; ok {
+			f.Type = t
+		}
+		families = append(families, f)
+	}
+	sort.Slice(families, func(i, j int) bool {
+		return families[i].Name < families[j].Name
+	})
+	return families
+}
+
+// describe returns c's descriptions.
+func describe(c prometheus.Collector) []*prometheus.Desc {
+	ch := make(chan *prometheus.Desc)
+	go func() {
+		c.Describe(ch)
+		close(ch)
+	}()
+	descs := []*prometheus.Desc{}
+	for d := range ch {
+		descs = append(descs, d)
+	}
+	return descs
+}
+
+// family returns the name, help and labels of the metric family d
+// describes, which Desc doesn't expose, by gathering a metric made
+// from it, or false if d is invalid.
+func family(d *prometheus.Desc) (Family, bool) {
+	var m prometheus.Metric
+	for n := 0; m == nil && n <= maxLabels; n++ {
+		m, _ = prometheus.NewConstMetric(d, prometheus.UntypedValue, 0, make([]string, n)...)
+	}
+	if m == nil {
+		return Family{}, false
+	}
+	reg := prometheus.NewRegistry()
+	if err := reg.Register(metricCollector{m}); err != nil {
+		return Family{}, false
+	}
+	mfs, err := reg.Gather()
+	if err != nil || len(mfs) != 1 || len(mfs[0].Metric) != 1 {
+		return Family{}, false
+	}
+	labels := []string{}
+	for _, lp := range mfs[0].Metric[0].Label {
+		labels = append(labels, lp.GetName())
+	}
+	sort.Strings(labels)
+	return Family{Name: mfs[0].GetName(), Help: mfs[0].GetHelp(), Labels: labels}, true
+}
+
+// metricCollector is an unchecked collector of a single metric.
+type metricCollector struct {
+	m prometheus.Metric
+}
+
+func (c metricCollector) Describe(chan<- *prometheus.Desc) {}
+
+func (c metricCollector) Collect(ch chan<- prometheus.Metric) {
+	ch <- c.m
+}
+
+// typed is implemented by custom collectors which declare the type of
+// their metrics, as it can't be found from them before they have series.
+type typed interface {
+	MetricType() dto.MetricType
+}
+
+// collectorType returns the type of c's metrics, from the vector's
+// type, or otherwise by collecting its metric.
+func collectorType(c prometheus.Collector) string {
+	switch c := c.(type) {
+	case *prometheus.CounterVec:
+		return "counter"
+	case *prometheus.GaugeVec:
+		return "gauge"
+	case *prometheus.HistogramVec:
+		return "histogram"
+	case *prometheus.SummaryVec:
+		return "summary"
+	case typed:
+		return typeName(c.MetricType())
+	}
+
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(metrics)
+		close(metrics)
+	}()
+	t := "untyped"
+	for metric := range metrics {
+		m := &dto.Metric{}
+		if err := metric.Write(m); err != nil {
+			continue
+		}
+		switch {
+		case m.Counter != nil:
+			t = "counter"
+		case m.Gauge != nil:
+			t = "gauge"
+		case m.Histogram != nil:
+			t = "histogram"
+		case m.Summary != nil:
+			t = "summary"
+		}
+	}
+	return t
+}
+
+// typeName returns the name of t as list-metrics shows it.
+func typeName(t dto.MetricType) string {
+	switch t {
+	case dto.MetricType_COUNTER:
+		return "counter"
+	case dto.MetricType_GAUGE:
+		return "gauge"
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		return "histogram"
+	case dto.MetricType_SUMMARY:
+		return "summary"
+	}
+	return "untyped"
+}
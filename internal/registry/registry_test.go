@@ -0,0 +1,29 @@
+package registry
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestFamilies(t *testing.T) {
+	Auto.NewGaugeVec(prometheus.GaugeOpts{
+		Name:        "registry_test_gauge",
+		Help:        "A gauge with no series",
+		ConstLabels: prometheus.Labels{"env": "test"},
+	}, []string{"node", "database"})
+
+	got := map[string]Family{}
+	for _, f := range Families() {
+		got[f.Name] = f
+	}
+	want := Family{Name: "registry_test_gauge", Type: "gauge", Help: "A gauge with no series", Labels: []string{"database", "env", "node"}}
+	if !reflect.DeepEqual(got[want.Name], want) {
+		t.Errorf("got %+v, want %+v", got[want.Name], want)
+	}
+	// registered by the default registry itself, not through Registerer
+	if got["go_goroutines"].Type != "gauge" {
+		t.Errorf("go_goroutines = %+v, want a gauge", got["go_goroutines"])
+	}
+}
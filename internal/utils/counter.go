@@ -116,11 +116,10 @@ func NewSettableCounterVec(opts prometheus.Opts, labelNames []string) *SettableC
 	}
 }
 
-// AutoNewSettableCounterVec creates and MustRegisters() a new SettableCounterVec.
-func AutoNewSettableCounterVec(opts prometheus.Opts, labelNames []string) *SettableCounterVec {
-	v := NewSettableCounterVec(opts, labelNames)
-	prometheus.DefaultRegisterer.MustRegister(v)
-	return v
+// MetricType returns the type of the vector's metrics, so that they
+// can be listed before they have any series.
+func (v *SettableCounterVec) MetricType() dto.MetricType {
+	return dto.MetricType_COUNTER
 }
 
 // GetMetricWithLabelValues is required to customise MetricVec (see SettableCounterVec doc)
@@ -11,7 +11,8 @@ type FailBox struct {
 }
 
 // NewFailBox returns a new FailBox that will trip after
-// continuous failures for failAfter time.
+// continuous failures for failAfter time. If failAfter is
+// zero, it never trips.
 func NewFailBox(failAfter time.Duration) *FailBox {
 	return &FailBox{
 		lastSuccess: time.Now(),
@@ -24,7 +25,7 @@ func (fb *FailBox) Success() {
 }
 
 func (fb *FailBox) Failure() {
-	if time.Since(fb.lastSuccess) > fb.failAfter {
+	if fb.failAfter > 0 && time.Since(fb.lastSuccess) > fb.failAfter {
 		fb.tripped = true
 	}
 }
@@ -33,6 +34,10 @@ func (fb *FailBox) ShouldExit() bool {
 	return fb.tripped
 }
 
+func (fb *FailBox) FailAfter() time.Duration {
+	return fb.failAfter
+}
+
 func (fb *FailBox) LastSuccess() time.Time {
 	return fb.lastSuccess
 }
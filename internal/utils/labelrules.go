@@ -0,0 +1,139 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// LabelRule drops labels from the metrics whose names match Metric.
+// If Keep is set, every label except those in Labels is dropped instead.
+type LabelRule struct {
+	Metric *regexp.Regexp
+	Labels map[string]bool
+	Keep   bool
+}
+
+// ParseLabelRule parses a rule given as "metric-regexp=label,label".
+// The regexp must match the whole metric name.
+func ParseLabelRule(s string, keep bool) (LabelRule, error) {
+	expr, labels, ok := strings.Cut(s, "=")
+	if !ok || expr == "" || labels == "" {
+		return LabelRule{}, fmt.Errorf("invalid label rule %q, expected metric-regexp=label,label", s)
+	}
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return LabelRule{}, fmt.Errorf("invalid label rule %q: %w", s, err)
+	}
+	r := LabelRule{Metric: re, Labels: map[string]bool{}, Keep: keep}
+	for _, l := range strings.Split(labels, ",") {
+		if l = strings.TrimSpace(l); l != "" {
+			r.Labels[l] = true
+		}
+	}
+	return r, nil
+}
+
+// LabelRulesGatherer applies Rules to the metrics gathered by Gatherer.
+// Series left with the same labels once some are dropped are merged
+// by summing them, so dropping eg a document ID turns a per-document
+// metric into a total. The families it changes are copied first, as
+// Gatherer may return the same ones to other scrapes.
+type LabelRulesGatherer struct {
+	Gatherer prometheus.Gatherer
+	Rules    []LabelRule
+}
+
+func (lg *LabelRulesGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := lg.Gatherer.Gather()
+	for i, mf := range mfs {
+		applied := false
+		for _, r := range lg.Rules {
+			if !r.Metric.MatchString(mf.GetName()) {
+				continue
+			}
+			if !applied {
+				mf = cloneFamily(mf)
+				mfs[i] = mf
+			}
+			for _, m := range mf.Metric {
+				m.Label = r.apply(m.Label)
+			}
+			applied = true
+		}
+		if applied {
+			mf.Metric = mergeMetrics(mf.GetType(), mf.Metric)
+		}
+	}
+	return mfs, err
+}
+
+func (r LabelRule) apply(labels []*dto.LabelPair) []*dto.LabelPair {
+	kept := labels[:0]
+	for _, lp := range labels {
+		if r.Labels[lp.GetName()] == r.Keep {
+			kept = append(kept, lp)
+		}
+	}
+	return kept
+}
+
+// mergeMetrics sums the metrics with identical labels.
+func mergeMetrics(t dto.MetricType, ms []*dto.Metric) []*dto.Metric {
+	merged := []*dto.Metric{}
+	byLabels := map[string]*dto.Metric{}
+	for _, m := range ms {
+		key := labelsKey(m.Label)
+		into, ok := byLabels[key]
+		if !ok {
+			byLabels[key] = m
+			merged = append(merged, m)
+			continue
+		}
+		addMetric(t, into, m)
+	}
+	return merged
+}
+
+func labelsKey(labels []*dto.LabelPair) string {
+	parts := make([]string, 0, len(labels))
+	for _, lp := range labels {
+		parts = append(parts, lp.GetName()+"\xff"+lp.GetValue())
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, "\xfe")
+}
+
+// addMetric adds m's values to into. Summaries' quantiles can't be
+// added, so only their counts and sums are, and the quantiles dropped.
+func addMetric(t dto.MetricType, into, m *dto.Metric) {
+	switch t {
+	case dto.MetricType_COUNTER:
+		into.Counter.Value = proto.Float64(into.Counter.GetValue() + m.Counter.GetValue())
+	case dto.MetricType_GAUGE:
+		into.Gauge.Value = proto.Float64(into.Gauge.GetValue() + m.Gauge.GetValue())
+	case dto.MetricType_UNTYPED:
+		into.Untyped.Value = proto.Float64(into.Untyped.GetValue() + m.Untyped.GetValue())
+	case dto.MetricType_SUMMARY:
+		into.Summary.SampleCount = proto.Uint64(into.Summary.GetSampleCount() + m.Summary.GetSampleCount())
+		into.Summary.SampleSum = proto.Float64(into.Summary.GetSampleSum() + m.Summary.GetSampleSum())
+		into.Summary.Quantile = nil
+	case dto.MetricType_HISTOGRAM, dto.MetricType_GAUGE_HISTOGRAM:
+		into.Histogram.SampleCount = proto.Uint64(into.Histogram.GetSampleCount() + m.Histogram.GetSampleCount())
+		into.Histogram.SampleSum = proto.Float64(into.Histogram.GetSampleSum() + m.Histogram.GetSampleSum())
+		buckets := map[float64]*dto.Bucket{}
+		for _, b := range into.Histogram.Bucket {
+			buckets[b.GetUpperBound()] = b
+		}
+		for _, b := range m.Histogram.Bucket {
+			if ib, ok := buckets[b.GetUpperBound()]; ok {
+				ib.CumulativeCount = proto.Uint64(ib.GetCumulativeCount() + b.GetCumulativeCount())
+			}
+		}
+	}
+}
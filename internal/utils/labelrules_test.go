@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"testing"
+
+	protov1 "github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestLabelRulesGathererLeavesSourceAlone(t *testing.T) {
+	reg := prometheus.NewRegistry()
+	vec := NewSettableCounterVec(prometheus.Opts{Name: "cloudant_test_total", Help: "test"}, []string{"database", "docid"})
+	reg.MustRegister(vec)
+	vec.WithLabelValues("_replicator", "a").Set(1)
+	vec.WithLabelValues("_replicator", "b").Set(2)
+
+	rule, err := ParseLabelRule("cloudant_test_total=docid", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &LabelsGatherer{
+		Gatherer: &PrefixGatherer{
+			Gatherer: &LabelRulesGatherer{Gatherer: reg, Rules: []LabelRule{rule}},
+			Prefix:   "couchdb_",
+		},
+		Labels: map[string]string{"instance": "test"},
+	}
+
+	// gathering repeatedly must give the same result, so the wrappers
+	// mustn't have changed the metrics they were given
+	for i := 0; i < 2; i++ {
+		mfs, err := g.Gather()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(mfs) != 1 || mfs[0].GetName() != "couchdb_test_total" || len(mfs[0].Metric) != 1 {
+			t.Fatalf("gather %d: got %v", i, mfs)
+		}
+		want := &dto.Metric{
+			Label: []*dto.LabelPair{
+				{Name: proto.String("database"), Value: proto.String("_replicator")},
+				{Name: proto.String("instance"), Value: proto.String("test")},
+			},
+			Counter: &dto.Counter{Value: proto.Float64(3)},
+		}
+		if got := mfs[0].Metric[0]; !protov1.Equal(got, want) {
+			t.Errorf("gather %d: got %v, want %v", i, got, want)
+		}
+	}
+}
+
+func TestParseLabelRule(t *testing.T) {
+	tests := []struct {
+		in      string
+		keep    bool
+		labels  []string
+		matches []string
+		misses  []string
+		err     bool
+	}{
+		{in: "cloudant_replication_.*=docid", labels: []string{"docid"}, matches: []string{"cloudant_replication_docs_written_total"}, misses: []string{"cloudant_replications_total"}},
+		{in: "cloudant_a|cloudant_b=database, docid ,", keep: true, labels: []string{"database", "docid"}, matches: []string{"cloudant_a", "cloudant_b"}, misses: []string{"cloudant_ab", "xcloudant_a"}},
+		{in: "cloudant_a", err: true},
+		{in: "=docid", err: true},
+		{in: "cloudant_a=", err: true},
+		{in: "cloudant_(=docid", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.in, func(t *testing.T) {
+			r, err := ParseLabelRule(tt.in, tt.keep)
+			if tt.err {
+				if err == nil {
+					t.Errorf("expected an error, got %+v", r)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Keep != tt.keep {
+				t.Errorf("Keep = %v, want %v", r.Keep, tt.keep)
+			}
+			if len(r.Labels) != len(tt.labels) {
+				t.Errorf("Labels = %v, want %v", r.Labels, tt.labels)
+			}
+			for _, l := range tt.labels {
+				if !r.Labels[l] {
+					t.Errorf("Labels = %v, want %v", r.Labels, tt.labels)
+				}
+			}
+			for _, name := range tt.matches {
+				if !r.Metric.MatchString(name) {
+					t.Errorf("%s doesn't match", name)
+				}
+			}
+			for _, name := range tt.misses {
+				if r.Metric.MatchString(name) {
+					t.Errorf("%s matches", name)
+				}
+			}
+		})
+	}
+}
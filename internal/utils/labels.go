@@ -0,0 +1,50 @@
+package utils
+
+import (
+	"sort"
+
+	protov1 "github.com/golang/protobuf/proto"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// LabelsGatherer adds the constant Labels to every metric gathered
+// by Gatherer, unless the metric already has a label of that name.
+// It changes copies of the families, as Gatherer may return the same
+// ones to other scrapes.
+type LabelsGatherer struct {
+	Gatherer prometheus.Gatherer
+	Labels   map[string]string
+}
+
+func (lg *LabelsGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := lg.Gatherer.Gather()
+	for i, mf := range mfs {
+		mf = cloneFamily(mf)
+		mfs[i] = mf
+		for _, m := range mf.Metric {
+			lg.addLabels(m)
+		}
+	}
+	return mfs, err
+}
+
+func (lg *LabelsGatherer) addLabels(m *dto.Metric) {
+	has := map[string]bool{}
+	for _, lp := range m.Label {
+		has[lp.GetName()] = true
+	}
+	for name, value := range lg.Labels {
+		if !has[name] {
+			m.Label = append(m.Label, &dto.LabelPair{Name: proto.String(name), Value: proto.String(value)})
+		}
+	}
+	sort.Sort(LabelPairSorter(m.Label))
+}
+
+// cloneFamily returns a deep copy of mf. client_model's types only
+// implement the older protobuf API, so can't use proto.Clone.
+func cloneFamily(mf *dto.MetricFamily) *dto.MetricFamily {
+	return protov1.Clone(mf).(*dto.MetricFamily)
+}
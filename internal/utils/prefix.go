@@ -0,0 +1,38 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"google.golang.org/protobuf/proto"
+)
+
+// DefaultMetricPrefix is the prefix the exporter's metrics are
+// registered with.
+const DefaultMetricPrefix = "cloudant_"
+
+// PrefixGatherer renames the metric families gathered by Gatherer,
+// replacing DefaultMetricPrefix with Prefix. Other metrics, eg the
+// Go runtime's, are left alone. It renames copies of the families,
+// as Gatherer may return the same ones to other scrapes.
+type PrefixGatherer struct {
+	Gatherer prometheus.Gatherer
+	Prefix   string
+}
+
+func (pg *PrefixGatherer) Gather() ([]*dto.MetricFamily, error) {
+	mfs, err := pg.Gatherer.Gather()
+	for i, mf := range mfs {
+		if name := mf.GetName(); strings.HasPrefix(name, DefaultMetricPrefix) {
+			mf = cloneFamily(mf)
+			mfs[i] = mf
+			mf.Name = proto.String(pg.Prefix + strings.TrimPrefix(name, DefaultMetricPrefix))
+		}
+	}
+	sort.Slice(mfs, func(i, j int) bool {
+		return mfs[i].GetName() < mfs[j].GetName()
+	})
+	return mfs, err
+}
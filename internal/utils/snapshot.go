@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// SnapshotHistogramVec is a histogram of values which each poll
+// replaces wholesale, eg the progress of the tasks running now.
+// Rather than Reset()ing a HistogramVec, which empties it part way
+// through a poll and makes its buckets go backwards, the histogram
+// is built at scrape time from the last snapshot. It describes the
+// values right now, not over time, so use histogram_quantile() on
+// it directly rather than on its rate().
+type SnapshotHistogramVec struct {
+	desc    *prometheus.Desc
+	buckets []float64
+
+	mu       sync.Mutex
+	snapshot map[string][]float64
+}
+
+// NewSnapshotHistogramVec creates a new SnapshotHistogramVec.
+func NewSnapshotHistogramVec(opts prometheus.HistogramOpts, labelNames []string) *SnapshotHistogramVec {
+	buckets := opts.Buckets
+	if buckets == nil {
+		buckets = prometheus.DefBuckets
+	}
+	return &SnapshotHistogramVec{
+		desc: prometheus.NewDesc(
+			prometheus.BuildFQName(opts.Namespace, opts.Subsystem, opts.Name),
+			opts.Help,
+			labelNames,
+			opts.ConstLabels,
+		),
+		buckets:  buckets,
+		snapshot: map[string][]float64{},
+	}
+}
+
+// MetricType returns the type of the vector's metrics, so that they
+// can be listed before they have any series.
+func (v *SnapshotHistogramVec) MetricType() dto.MetricType {
+	return dto.MetricType_HISTOGRAM
+}
+
+// Set replaces the snapshot with values, which are keyed by their
+// label values joined with LabelsKey.
+func (v *SnapshotHistogramVec) Set(values map[string][]float64) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.snapshot = values
+}
+
+// Describe implements prometheus.Collector.
+func (v *SnapshotHistogramVec) Describe(ch chan<- *prometheus.Desc) {
+	ch <- v.desc
+}
+
+// Collect implements prometheus.Collector.
+func (v *SnapshotHistogramVec) Collect(ch chan<- prometheus.Metric) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	for key, values := range v.snapshot {
+		sorted := append([]float64{}, values...)
+		sort.Float64s(sorted)
+		var sum float64
+		for _, value := range sorted {
+			sum += value
+		}
+		buckets := make(map[float64]uint64, len(v.buckets))
+		for _, upper := range v.buckets {
+			buckets[upper] = uint64(sort.Search(len(sorted), func(i int) bool { return sorted[i] > upper }))
+		}
+		ch <- prometheus.MustNewConstHistogram(v.desc, uint64(len(sorted)), sum, buckets, strings.Split(key, labelsKeySep)...)
+	}
+}
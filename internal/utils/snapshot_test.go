@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestSnapshotHistogramVec(t *testing.T) {
+	vec := NewSnapshotHistogramVec(prometheus.HistogramOpts{
+		Name:    "progress",
+		Help:    "progress",
+		Buckets: []float64{50, 100},
+	}, []string{"type"})
+
+	vec.Set(map[string][]float64{"indexer": {10, 50, 90}})
+	vec.Set(map[string][]float64{"view_compaction": {20, 60}})
+
+	want := `# HELP progress progress
+# TYPE progress histogram
+progress_bucket{type="view_compaction",le="50"} 1
+progress_bucket{type="view_compaction",le="100"} 2
+progress_bucket{type="view_compaction",le="+Inf"} 2
+progress_sum{type="view_compaction"} 80
+progress_count{type="view_compaction"} 2
+`
+	if err := testutil.CollectAndCompare(vec, strings.NewReader(want)); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// DeleteStale deletes the series of vec for which keep returns false,
+// eg those of databases deleted since the last poll. Unlike Reset,
+// the series kept never disappear, even briefly, from a scrape.
+func DeleteStale(vec *prometheus.MetricVec, keep func(prometheus.Labels) bool) {
+	metrics := make(chan prometheus.Metric)
+	go func() {
+		vec.Collect(metrics)
+		close(metrics)
+	}()
+	// collect them all before deleting, as Collect holds vec's lock
+	stale := []prometheus.Labels{}
+	for m := range metrics {
+		pb := &dto.Metric{}
+		if err := m.Write(pb); err != nil {
+			continue
+		}
+		labels := prometheus.Labels{}
+		for _, lp := range pb.Label {
+			labels[lp.GetName()] = lp.GetValue()
+		}
+		if !keep(labels) {
+			stale = append(stale, labels)
+		}
+	}
+	for _, labels := range stale {
+		vec.Delete(labels)
+	}
+}
+
+// KeepLabel returns a function for DeleteStale keeping the series
+// whose value of the label is in values.
+func KeepLabel(label string, values map[string]bool) func(prometheus.Labels) bool {
+	return func(labels prometheus.Labels) bool {
+		return values[labels[label]]
+	}
+}
+
+// KeepLabels is KeepLabel for several labels, whose values are joined
+// with LabelsKey to look them up in values.
+func KeepLabels(labels []string, values map[string]bool) func(prometheus.Labels) bool {
+	return func(l prometheus.Labels) bool {
+		vs := make([]string, len(labels))
+		for i, label := range labels {
+			vs[i] = l[label]
+		}
+		return values[LabelsKey(vs...)]
+	}
+}
+
+// labelsKeySep separates the values in a LabelsKey; label values
+// can be any UTF-8, but NUL is vanishingly unlikely.
+const labelsKeySep = "\x00"
+
+// LabelsKey joins label values into a key for KeepLabels.
+func LabelsKey(values ...string) string {
+	return strings.Join(values, labelsKeySep)
+}
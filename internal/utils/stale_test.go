@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestDeleteStale(t *testing.T) {
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test", Help: "test"}, []string{"database"})
+	for _, db := range []string{"a", "b", "c"} {
+		vec.WithLabelValues(db).Set(1)
+	}
+
+	DeleteStale(vec.MetricVec, KeepLabel("database", map[string]bool{"a": true, "c": true}))
+
+	if n := testutil.CollectAndCount(vec); n != 2 {
+		t.Fatalf("got %d series, want 2", n)
+	}
+	if v := testutil.ToFloat64(vec.WithLabelValues("a")); v != 1 {
+		t.Errorf("series a = %v, want 1", v)
+	}
+	if vec.DeleteLabelValues("b") {
+		t.Errorf("series b was not deleted")
+	}
+}
+
+func TestKeepLabels(t *testing.T) {
+	vec := prometheus.NewGaugeVec(prometheus.GaugeOpts{Name: "test", Help: "test"}, []string{"database", "design_document"})
+	vec.WithLabelValues("a", "x").Set(1)
+	vec.WithLabelValues("a", "y").Set(1)
+	vec.WithLabelValues("b", "x").Set(1)
+
+	keep := map[string]bool{LabelsKey("a", "y"): true, LabelsKey("b", "x"): true}
+	DeleteStale(vec.MetricVec, KeepLabels([]string{"database", "design_document"}, keep))
+
+	if n := testutil.CollectAndCount(vec); n != 2 {
+		t.Fatalf("got %d series, want 2", n)
+	}
+	if vec.DeleteLabelValues("a", "x") {
+		t.Errorf("series a/x was not deleted")
+	}
+}
@@ -0,0 +1,239 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloudant.com/cloudant_exporter/internal/registry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// serviceAccountTokenPath is where Kubernetes mounts the pod's
+// service account token, used to log in to Vault with a role.
+const serviceAccountTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token" //nolint:gosec // not a credential
+
+// retryInterval is how soon to try again after failing to renew.
+const retryInterval = 30 * time.Second
+
+var (
+	renewalFailures = registry.Auto.NewCounter(prometheus.CounterOpts{
+		Name: "cloudant_exporter_vault_renewal_failures_total",
+		Help: "The number of times the exporter failed to renew its Vault token or secret lease",
+	})
+)
+
+// Client reads secrets from HashiCorp Vault over its HTTP API. It logs
+// in with VAULT_TOKEN if that's set, or otherwise as Role, with the
+// pod's Kubernetes service account, at the auth method at AuthPath.
+type Client struct {
+	Addr     string
+	AuthPath string
+	Role     string
+	HTTP     *http.Client
+
+	mu             sync.Mutex
+	token          string
+	tokenTTL       time.Duration
+	tokenRenewable bool
+	leaseID        string
+	leaseTTL       time.Duration
+}
+
+// response is the envelope of Vault's API responses.
+type response struct {
+	LeaseID       string                 `json:"lease_id"`
+	LeaseDuration int64                  `json:"lease_duration"`
+	Renewable     bool                   `json:"renewable"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int64  `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// Login gets a Vault token.
+func (c *Client) Login() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if token := os.Getenv("VAULT_TOKEN"); token != "" {
+		c.token = token
+		// look the token up, to know when it needs renewing
+		resp := &response{}
+		if err := c.do(http.MethodGet, "auth/token/lookup-self", nil, resp); err != nil {
+			return err
+		}
+		ttl, _ := resp.Data["ttl"].(float64)
+		renewable, _ := resp.Data["renewable"].(bool)
+		c.tokenTTL, c.tokenRenewable = time.Duration(ttl)*time.Second, renewable
+		return nil
+	}
+
+	if c.Role == "" {
+		return errors.New("neither VAULT_TOKEN nor a role to log in as is set")
+	}
+	jwt, err := os.ReadFile(serviceAccountTokenPath)
+	if err != nil {
+		return fmt.Errorf("could not read service account token: %w", err)
+	}
+	resp := &response{}
+	body := map[string]string{"role": c.Role, "jwt": strings.TrimSpace(string(jwt))}
+	if err := c.do(http.MethodPost, "auth/"+strings.Trim(c.AuthPath, "/")+"/login", body, resp); err != nil {
+		return err
+	}
+	if resp.Auth == nil {
+		return errors.New("login response has no token")
+	}
+	c.token = resp.Auth.ClientToken
+	c.tokenTTL = time.Duration(resp.Auth.LeaseDuration) * time.Second
+	c.tokenRenewable = resp.Auth.Renewable
+	return nil
+}
+
+// Read returns field of the secret at path, which may be in a version 1
+// or version 2 KV secrets engine. If the secret has a lease, Renew
+// keeps it alive.
+func (c *Client) Read(path, field string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	resp := &response{}
+	if err := c.do(http.MethodGet, strings.Trim(path, "/"), nil, resp); err != nil {
+		return "", err
+	}
+	data := resp.Data
+	// KV version 2 nests the secret inside its metadata
+	if inner, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = inner
+	}
+	value, ok := data[field].(string)
+	if !ok {
+		return "", fmt.Errorf("secret %s has no %q field", path, field)
+	}
+	if resp.LeaseID != "" && resp.Renewable {
+		c.leaseID = resp.LeaseID
+		c.leaseTTL = time.Duration(resp.LeaseDuration) * time.Second
+	}
+	return value, nil
+}
+
+// Renew keeps the token, and the lease of the secret read, if any,
+// alive, renewing each when two thirds of its TTL have passed. If the
+// token can't be renewed, it logs in again. It runs until the process
+// exits, so should be run in its own goroutine.
+func (c *Client) Renew() {
+	for {
+		c.mu.Lock()
+		wait := renewAfter(c.tokenTTL, c.leaseTTL)
+		c.mu.Unlock()
+		if wait == 0 {
+			// nothing expires
+			return
+		}
+		time.Sleep(wait)
+
+		if err := c.renew(); err != nil {
+			slog.Error("Could not renew Vault token or lease", "err", err)
+			renewalFailures.Inc()
+			time.Sleep(retryInterval)
+		}
+	}
+}
+
+func (c *Client) renew() error {
+	c.mu.Lock()
+	renewable := c.tokenRenewable
+	c.mu.Unlock()
+
+	if renewable {
+		if err := c.renewToken(); err != nil {
+			return err
+		}
+	} else if err := c.Login(); err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.leaseID == "" {
+		return nil
+	}
+	resp := &response{}
+	if err := c.do(http.MethodPut, "sys/leases/renew", map[string]string{"lease_id": c.leaseID}, resp); err != nil {
+		return fmt.Errorf("lease: %w", err)
+	}
+	c.leaseTTL = time.Duration(resp.LeaseDuration) * time.Second
+	return nil
+}
+
+func (c *Client) renewToken() error {
+	c.mu.Lock()
+	resp := &response{}
+	err := c.do(http.MethodPost, "auth/token/renew-self", map[string]string{}, resp)
+	if err == nil && resp.Auth != nil {
+		c.tokenTTL = time.Duration(resp.Auth.LeaseDuration) * time.Second
+	}
+	c.mu.Unlock()
+	if err != nil && c.Role != "" {
+		// the token may have reached its max TTL, so start again
+		slog.Warn("Could not renew Vault token, logging in again", "err", err)
+		return c.Login()
+	}
+	return err
+}
+
+// renewAfter returns two thirds of the shorter non-zero TTL,
+// or zero if neither expires.
+func renewAfter(ttls ...time.Duration) time.Duration {
+	var shortest time.Duration
+	for _, ttl := range ttls {
+		if ttl > 0 && (shortest == 0 || ttl < shortest) {
+			shortest = ttl
+		}
+	}
+	return shortest * 2 / 3
+}
+
+// do calls the Vault API, decoding the response into out.
+// c.mu must be held.
+func (c *Client) do(method, path string, body interface{}, out *response) error {
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, strings.TrimRight(c.Addr, "/")+"/v1/"+path, reqBody)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Vault-Token", c.token)
+	}
+
+	resp, err := c.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil && err != io.EOF {
+		return fmt.Errorf("%s %s: %s: %w", method, path, resp.Status, err)
+	}
+	if resp.StatusCode >= 400 {
+		return fmt.Errorf("%s %s: %s: %s", method, path, resp.Status, strings.Join(out.Errors, "; "))
+	}
+	return nil
+}